@@ -0,0 +1,304 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package archive unpacks and packs image layers. Layers mark deleted files
+// with whiteouts, which are translated from and to the format of the storage
+// driver the layer is for.
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// WhiteoutPrefix marks a deleted file, .wh.<name> deletes <name>
+	WhiteoutPrefix = ".wh."
+	// WhiteoutOpaqueDir hides everything below the directory it is in that
+	// comes from lower layers
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+	// whiteoutMetaPrefix marks bookkeeping of aufs, which is not content
+	whiteoutMetaPrefix = WhiteoutPrefix + WhiteoutPrefix
+
+	paxXattrPrefix = "SCHILY.xattr."
+)
+
+// WhiteoutConverter writes and recognizes whiteouts in the native format of
+// a storage driver.
+type WhiteoutConverter interface {
+	// Whiteout marks name in dir as deleted
+	Whiteout(dir, name string) error
+	// Opaque marks dir as hiding the content of lower layers
+	Opaque(dir string) error
+	// IsWhiteout tells whether a file marks its name as deleted
+	IsWhiteout(fi os.FileInfo) bool
+	// IsOpaque tells whether dir hides the content of lower layers
+	IsOpaque(dir string) (bool, error)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Decompress detects gzip and zstd compressed streams, others are passed
+// through.
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// Apply unpacks an uncompressed layer tar into dir. Entries which would end
+// up outside of dir are rejected, symlinks are resolved as if dir was the
+// root.
+func Apply(r io.Reader, dir string, converter WhiteoutConverter) error {
+	type dirTimes struct {
+		path  string
+		mtime time.Time
+	}
+	var dirs []dirTimes
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, err := entryName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			continue
+		}
+
+		parent, err := ResolveInRoot(dir, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+		base := filepath.Base(name)
+
+		if base == WhiteoutOpaqueDir {
+			if err := converter.Opaque(parent); err != nil {
+				return fmt.Errorf("opaque %s, err: %v", name, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(base, WhiteoutPrefix) {
+			deleted := strings.TrimPrefix(base, WhiteoutPrefix)
+			if deleted == "" || deleted == "." || deleted == ".." || strings.Contains(deleted, "/") {
+				return fmt.Errorf("invalid whiteout %s", name)
+			}
+			if err := converter.Whiteout(parent, deleted); err != nil {
+				return fmt.Errorf("whiteout %s, err: %v", name, err)
+			}
+			continue
+		}
+
+		target := filepath.Join(parent, base)
+		if err := createEntry(dir, target, hdr, tr); err != nil {
+			return fmt.Errorf("unpack %s, err: %v", name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, dirTimes{path: target, mtime: hdr.ModTime})
+		}
+	}
+
+	// adding entries changed the times of the directories
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = setTimes(dirs[i].path, dirs[i].mtime)
+	}
+	return nil
+}
+
+// entryName cleans an entry name and rejects names leaving the archive.
+func entryName(name string) (string, error) {
+	cleaned := filepath.Clean(strings.TrimPrefix(name, "/"))
+	if cleaned == "." {
+		return "", nil
+	}
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("archive entry %s escapes the archive", name)
+	}
+	return cleaned, nil
+}
+
+func createEntry(root, target string, hdr *tar.Header, r io.Reader) error {
+	mode := os.FileMode(hdr.Mode).Perm()
+	if hdr.Mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if hdr.Mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if hdr.Mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	// a later layer entry replaces what is there, except that directories
+	// are merged
+	if fi, err := os.Lstat(target); err == nil {
+		if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, mode); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		linkName, err := entryName(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		source, err := ResolveInRoot(root, linkName)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+		// the link shares owner, mode and times with its source
+		return nil
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		fileType := uint32(unix.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			fileType = unix.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			fileType = unix.S_IFBLK
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(target, fileType|uint32(mode.Perm()), int(dev)); err != nil {
+			return err
+		}
+	default:
+		// pax headers and the like carry no file
+		return nil
+	}
+
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		if err := unix.Lsetxattr(target, strings.TrimPrefix(key, paxXattrPrefix), []byte(value), 0); err != nil && err != unix.ENOTSUP {
+			return fmt.Errorf("set xattr %s, err: %v", key, err)
+		}
+	}
+	if hdr.Typeflag != tar.TypeSymlink {
+		// chown drops the set id bits
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		return setTimes(target, hdr.ModTime)
+	}
+	return nil
+}
+
+func setTimes(target string, mtime time.Time) error {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	return unix.UtimesNanoAt(unix.AT_FDCWD, target, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// ResolveInRoot resolves the symlinks of a relative path as if root was the
+// root directory, so the result never leaves root. Missing components are
+// taken as they are.
+func ResolveInRoot(root, name string) (string, error) {
+	const maxLinks = 255
+	links := 0
+	resolved := ""
+	pending := strings.Split(filepath.Clean("/"+name), "/")
+	for len(pending) > 0 {
+		component := pending[0]
+		pending = pending[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir("/" + resolved)
+			resolved = strings.TrimPrefix(resolved, "/")
+			continue
+		}
+		next := filepath.Join(resolved, component)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxLinks {
+			return "", fmt.Errorf("too many links resolving %s", name)
+		}
+		dest, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			resolved = ""
+		}
+		pending = append(strings.Split(dest, "/"), pending...)
+	}
+	return filepath.Join(root, resolved), nil
+}
@@ -0,0 +1,329 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// recordingConverter records the whiteouts and opaque directories relative
+// to the directory a layer is applied to.
+type recordingConverter struct {
+	root      string
+	whiteouts []string
+	opaques   []string
+}
+
+func (c *recordingConverter) Whiteout(dir, name string) error {
+	rel, _ := filepath.Rel(c.root, filepath.Join(dir, name))
+	c.whiteouts = append(c.whiteouts, rel)
+	return nil
+}
+
+func (c *recordingConverter) Opaque(dir string) error {
+	rel, _ := filepath.Rel(c.root, dir)
+	c.opaques = append(c.opaques, rel)
+	return nil
+}
+
+func (c *recordingConverter) IsWhiteout(fi os.FileInfo) bool {
+	return false
+}
+
+func (c *recordingConverter) IsOpaque(dir string) (bool, error) {
+	return false, nil
+}
+
+// entry is a tar entry of a test layer, the content makes a regular file
+// unless the header says otherwise.
+type entry struct {
+	hdr     tar.Header
+	content string
+}
+
+func file(name, content string) entry {
+	return entry{hdr: tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}, content: content}
+}
+
+func dir(name string) entry {
+	return entry{hdr: tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755}}
+}
+
+func symlink(name, target string) entry {
+	return entry{hdr: tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target, Mode: 0777}}
+}
+
+func hardlink(name, target string) entry {
+	return entry{hdr: tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target}}
+}
+
+func layer(t *testing.T, entries ...entry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Uid, hdr.Gid = os.Getuid(), os.Getgid()
+		hdr.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func apply(t *testing.T, entries ...entry) (string, *recordingConverter, error) {
+	t.Helper()
+	root := t.TempDir()
+	converter := &recordingConverter{root: root}
+	return root, converter, Apply(layer(t, entries...), root, converter)
+}
+
+func TestApplyRejectsEntriesEscapingTheRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"parent", []entry{file("../escaped", "x")}},
+		{"absolute parent", []entry{file("/../../escaped", "x")}},
+		{"nested parent", []entry{file("a/../../escaped", "x")}},
+		{"hardlink to parent", []entry{hardlink("link", "../escaped")}},
+		{"whiteout of parent", []entry{file("a/.wh...", "")}},
+		{"whiteout of itself", []entry{file("a/.wh..", "")}},
+		{"empty whiteout", []entry{file("a/.wh.", "")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, converter, err := apply(t, tt.entries...)
+			if err == nil {
+				t.Fatalf("the layer is applied")
+			}
+			if _, err := os.Lstat(filepath.Join(filepath.Dir(root), "escaped")); !os.IsNotExist(err) {
+				t.Fatalf("an entry is written outside of the root, stat err: %v", err)
+			}
+			if len(converter.whiteouts) > 0 {
+				t.Fatalf("got whiteouts %v", converter.whiteouts)
+			}
+		})
+	}
+}
+
+func TestApplyResolvesSymlinksInTheRoot(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		entries []entry
+		// inside is where the file entry has to end up, relative to the root
+		inside string
+	}{
+		{
+			name:    "absolute symlink",
+			entries: []entry{symlink("link", outside), file("link/written", "x")},
+			inside:  filepath.Join(outside, "written"),
+		},
+		{
+			name:    "relative symlink",
+			entries: []entry{symlink("link", "../../../../../.."+outside), file("link/written", "x")},
+			inside:  filepath.Join(outside, "written"),
+		},
+		{
+			name:    "symlink to the parent",
+			entries: []entry{dir("a"), symlink("a/up", "../.."), file("a/up/written", "x")},
+			inside:  "written",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, _, err := apply(t, tt.entries...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(filepath.Join(outside, "written")); !os.IsNotExist(err) {
+				t.Fatalf("the file is written outside of the root, stat err: %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(root, tt.inside)); err != nil {
+				t.Fatalf("the file is not written to %s, err: %v", tt.inside, err)
+			}
+		})
+	}
+}
+
+func TestApplyHardlinks(t *testing.T) {
+	root, _, err := apply(t, file("a", "content"), hardlink("b", "a"), hardlink("/c", "/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := os.Stat(filepath.Join(root, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c"} {
+		fi, err := os.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(a, fi) {
+			t.Fatalf("%s is not a hard link of a", name)
+		}
+	}
+}
+
+func TestApplyHardlinkDoesNotFollowSymlinksOut(t *testing.T) {
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	root, _, err := apply(t, symlink("link", outside), hardlink("stolen", "link/secret"))
+	if err == nil {
+		fi, serr := os.Stat(filepath.Join(root, "stolen"))
+		sfi, _ := os.Stat(secret)
+		if serr == nil && os.SameFile(fi, sfi) {
+			t.Fatalf("a hard link to a file outside of the root is created")
+		}
+	}
+	if fi, err := os.Stat(secret); err != nil || fi.Sys().(*syscall.Stat_t).Nlink != 1 {
+		t.Fatalf("the file outside of the root got linked, err: %v", err)
+	}
+}
+
+func TestApplyWhiteouts(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []entry
+		whiteouts []string
+		opaques   []string
+	}{
+		{
+			name:      "whiteout",
+			entries:   []entry{file(".wh.deleted", "")},
+			whiteouts: []string{"deleted"},
+		},
+		{
+			name:      "nested whiteout",
+			entries:   []entry{dir("a"), file("a/b/.wh.deleted", "")},
+			whiteouts: []string{"a/b/deleted"},
+		},
+		{
+			name:    "opaque directory",
+			entries: []entry{dir("a"), file("a/.wh..wh..opq", ""), file("a/kept", "x")},
+			opaques: []string{"a"},
+		},
+		{
+			name:      "whiteout below a symlink",
+			entries:   []entry{dir("a"), symlink("link", "a"), file("link/.wh.deleted", "")},
+			whiteouts: []string{"a/deleted"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, converter, err := apply(t, tt.entries...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(converter.whiteouts, tt.whiteouts) {
+				t.Errorf("got whiteouts %v, want %v", converter.whiteouts, tt.whiteouts)
+			}
+			if !reflect.DeepEqual(converter.opaques, tt.opaques) {
+				t.Errorf("got opaque directories %v, want %v", converter.opaques, tt.opaques)
+			}
+			// the markers are never unpacked as files
+			_ = filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+				if err == nil && strings.HasPrefix(fi.Name(), WhiteoutPrefix) {
+					t.Errorf("the marker %s is unpacked", p)
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func TestApplyReplacesEntries(t *testing.T) {
+	root, _, err := apply(t,
+		dir("a"), file("a/old", "x"),
+		file("a", "now a file"),
+		symlink("b", "a"), file("b", "now a file too"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b"} {
+		fi, err := os.Lstat(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.Mode().IsRegular() {
+			t.Errorf("%s is %v, want a regular file", name, fi.Mode())
+		}
+	}
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, link := range []struct{ name, target string }{
+		{"abs", "/etc"},
+		{"rel", "../../etc"},
+		{"loop", "loop"},
+	} {
+		if err := os.Symlink(link.target, filepath.Join(root, link.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "a/b", want: "a/b"},
+		{name: "/a/../../b", want: "b"},
+		{name: "abs/passwd", want: "etc/passwd"},
+		{name: "rel/passwd", want: "etc/passwd"},
+		{name: "loop/x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveInRoot(root, tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ResolveInRoot(%s) = %s, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ResolveInRoot(%s), err: %v", tt.name, err)
+			continue
+		}
+		if want := filepath.Join(root, tt.want); got != want {
+			t.Errorf("ResolveInRoot(%s) = %s, want %s", tt.name, got, want)
+		}
+	}
+}
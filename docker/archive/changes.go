@@ -0,0 +1,176 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	ChangeAdd    = "A"
+	ChangeModify = "C"
+	ChangeDelete = "D"
+)
+
+// Change is a path a layer adds, changes or deletes compared to the layers
+// below it. Path is absolute in the root file system.
+type Change struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+func (c Change) String() string {
+	return c.Kind + " " + c.Path
+}
+
+// Changes compares a layer directory with the layers below it, given top
+// layer first. A deleted directory is reported, its content is not.
+func Changes(layerDir string, lowerDirs []string, converter WhiteoutConverter) ([]Change, error) {
+	lower := lowerView{dirs: lowerDirs, converter: converter}
+	var changes []Change
+	err := filepath.Walk(layerDir, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(layerDir, filePath)
+		if err != nil || name == "." {
+			return err
+		}
+		if base := fi.Name(); strings.HasPrefix(base, WhiteoutPrefix) {
+			if fi.IsDir() && strings.HasPrefix(base, whiteoutMetaPrefix) {
+				return filepath.SkipDir
+			}
+			if strings.HasPrefix(base, whiteoutMetaPrefix) {
+				return nil
+			}
+			deleted := filepath.Join(filepath.Dir(name), strings.TrimPrefix(base, WhiteoutPrefix))
+			if lower.exists(deleted) {
+				changes = append(changes, Change{Kind: ChangeDelete, Path: "/" + deleted})
+			}
+			return nil
+		}
+		if converter.IsWhiteout(fi) {
+			if lower.exists(name) {
+				changes = append(changes, Change{Kind: ChangeDelete, Path: "/" + name})
+			}
+			return nil
+		}
+
+		if !lower.exists(name) {
+			changes = append(changes, Change{Kind: ChangeAdd, Path: "/" + name})
+			return nil
+		}
+		changes = append(changes, Change{Kind: ChangeModify, Path: "/" + name})
+		if !fi.IsDir() {
+			return nil
+		}
+		opaque, err := isOpaque(filePath, converter)
+		if err != nil || !opaque {
+			return err
+		}
+		// an opaque directory replaces the lower one, what it lacks is gone
+		for _, child := range lower.children(name) {
+			if _, err := os.Lstat(filepath.Join(filePath, child)); os.IsNotExist(err) {
+				changes = append(changes, Change{Kind: ChangeDelete, Path: "/" + filepath.Join(name, child)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// isOpaque understands opaque directories of the storage driver as well as
+// the whiteout file layers use.
+func isOpaque(dir string, converter WhiteoutConverter) (bool, error) {
+	if _, err := os.Lstat(filepath.Join(dir, WhiteoutOpaqueDir)); err == nil {
+		return true, nil
+	}
+	return converter.IsOpaque(dir)
+}
+
+// lowerView looks paths up in stacked layer directories, top layer first,
+// the way the storage driver merges them.
+type lowerView struct {
+	dirs      []string
+	converter WhiteoutConverter
+}
+
+func (l lowerView) exists(name string) bool {
+	components := strings.Split(filepath.Clean(name), string(filepath.Separator))
+	for _, dir := range l.dirs {
+		opaque := false
+		for i, component := range components {
+			parent := filepath.Join(dir, filepath.Join(components[:i]...))
+			if _, err := os.Lstat(filepath.Join(parent, WhiteoutPrefix+component)); err == nil {
+				return false
+			}
+			fi, err := os.Lstat(filepath.Join(parent, component))
+			if err != nil {
+				break
+			}
+			if l.converter.IsWhiteout(fi) {
+				return false
+			}
+			if i == len(components)-1 {
+				return true
+			}
+			// a file hides whatever lower layers have below its path
+			if !fi.IsDir() {
+				return false
+			}
+			if hides, _ := isOpaque(filepath.Join(parent, component), l.converter); hides {
+				opaque = true
+			}
+		}
+		if opaque {
+			return false
+		}
+	}
+	return false
+}
+
+// children lists the names the merged lower layers have in a directory.
+func (l lowerView) children(name string) []string {
+	seen := map[string]bool{}
+	var children []string
+	for _, dir := range l.dirs {
+		infos, err := ioutil.ReadDir(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, fi := range infos {
+			child := fi.Name()
+			if seen[child] || strings.HasPrefix(child, WhiteoutPrefix) || l.converter.IsWhiteout(fi) {
+				continue
+			}
+			seen[child] = true
+			if l.exists(filepath.Join(name, child)) {
+				children = append(children, child)
+			}
+		}
+	}
+	sort.Strings(children)
+	return children
+}
@@ -0,0 +1,211 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package archive
+
+import (
+	"archive/tar"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// overlayXattrPrefix marks xattrs overlay keeps for itself
+const overlayXattrPrefix = "trusted.overlay."
+
+// Diff writes the content of a layer directory as a layer tar. Whiteouts in
+// the format of the storage driver become whiteout files again.
+func Diff(dir string, w io.Writer, converter WhiteoutConverter) error {
+	return pack(dir, w, converter, nil)
+}
+
+// Tar writes a directory as a tar, leaving out the content of the
+// directories excludes names relative to dir.
+func Tar(dir string, w io.Writer, excludes ...string) error {
+	return pack(dir, w, nil, excludes)
+}
+
+// pack walks dir in lexical order, with a converter the directory is taken
+// as a layer of its storage driver.
+func pack(dir string, w io.Writer, converter WhiteoutConverter, excludes []string) error {
+	excluded := map[string]bool{}
+	for _, exclude := range excludes {
+		if name, err := entryName(exclude); err == nil && name != "" {
+			excluded[name] = true
+		}
+	}
+	tw := tar.NewWriter(w)
+	// hard links are written as links to the first name of the inode
+	inodes := map[uint64]string{}
+	err := filepath.Walk(dir, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, filePath)
+		if err != nil || name == "." {
+			return err
+		}
+		if excluded[name] && fi.IsDir() {
+			if err := writeEntry(tw, filePath, name, fi, inodes); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		if converter == nil {
+			return writeEntry(tw, filePath, name, fi, inodes)
+		}
+		if strings.HasPrefix(fi.Name(), whiteoutMetaPrefix) && fi.Name() != WhiteoutOpaqueDir {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if converter.IsWhiteout(fi) {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     path.Join(path.Dir(name), WhiteoutPrefix+fi.Name()),
+				Mode:     0600,
+				ModTime:  fi.ModTime(),
+			})
+		}
+
+		if err := writeEntry(tw, filePath, name, fi, inodes); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			opaque, err := converter.IsOpaque(filePath)
+			if err != nil {
+				return err
+			}
+			if opaque {
+				return tw.WriteHeader(&tar.Header{
+					Typeflag: tar.TypeReg,
+					Name:     path.Join(name, WhiteoutOpaqueDir),
+					Mode:     0600,
+					ModTime:  fi.ModTime(),
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeEntry(tw *tar.Writer, filePath, name string, fi os.FileInfo, inodes map[uint64]string) error {
+	hdr, err := fileHeader(filePath, name, fi, inodes)
+	if err != nil || hdr == nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+		return nil
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(tw, f, hdr.Size)
+	return err
+}
+
+// fileHeader describes a file of a layer directory, sockets have no place
+// in a layer and get none.
+func fileHeader(filePath, name string, fi os.FileInfo, inodes map[uint64]string) (*tar.Header, error) {
+	if fi.Mode()&os.ModeSocket != 0 {
+		return nil, nil
+	}
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(filePath); err != nil {
+			return nil, err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	// owners are numeric in layers, the names are the ones of the host
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.ModTime = fi.ModTime().Truncate(time.Second)
+
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && !fi.IsDir() && st.Nlink > 1 {
+		if first, seen := inodes[st.Ino]; seen {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+		} else {
+			inodes[st.Ino] = name
+		}
+	}
+
+	xattrs, err := listXattrs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[paxXattrPrefix+key] = value
+	}
+	return hdr, nil
+}
+
+func listXattrs(filePath string) (map[string]string, error) {
+	size, err := unix.Llistxattr(filePath, nil)
+	if err != nil || size <= 0 {
+		if err == unix.ENOTSUP {
+			err = nil
+		}
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(filePath, buf)
+	if err != nil {
+		return nil, err
+	}
+	xattrs := map[string]string{}
+	for _, key := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if key == "" || strings.HasPrefix(key, overlayXattrPrefix) {
+			continue
+		}
+		value := make([]byte, 4096)
+		n, err := unix.Lgetxattr(filePath, key, value)
+		if err != nil {
+			if err == unix.ENODATA {
+				continue
+			}
+			return nil, err
+		}
+		xattrs[key] = string(value[:n])
+	}
+	return xattrs, nil
+}
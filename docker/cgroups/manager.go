@@ -15,8 +15,10 @@
 package cgroups
 
 import (
+	"fmt"
 	"github.com/go-kinds/docker/cgroups/subsystem"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 )
 
 type CGroupManager struct {
@@ -27,22 +29,26 @@ func NewCGroupManager(path string) *CGroupManager {
 	return &CGroupManager{Path: path}
 }
 
-func (c *CGroupManager) Set(res *subsystem.ResourceConfig) {
+func (c *CGroupManager) Set(res *subsystem.ResourceConfig) error {
 	for _, subsystem := range subsystem.Subsystems {
 		err := subsystem.Set(c.Path, res)
 		if err != nil {
 			logrus.Errorf("set %s err: %v", subsystem.Name(), err)
+			return fmt.Errorf("set %s, err: %v", subsystem.Name(), err)
 		}
 	}
+	return nil
 }
 
-func (c *CGroupManager) Apply(pid int) {
+func (c *CGroupManager) Apply(pid int) error {
 	for _, subsystem := range subsystem.Subsystems {
 		err := subsystem.Apply(c.Path, pid)
 		if err != nil {
 			logrus.Errorf("apply task, err: %v", err)
+			return fmt.Errorf("apply %s, err: %v", subsystem.Name(), err)
 		}
 	}
+	return nil
 }
 
 func (c *CGroupManager) Destroy() {
@@ -54,3 +60,37 @@ func (c *CGroupManager) Destroy() {
 	}
 
 }
+
+// Freeze stops the processes of the cgroup until Thaw.
+func (c *CGroupManager) Freeze() error {
+	return subsystem.SetFreezerState(c.Path, subsystem.Frozen)
+}
+
+func (c *CGroupManager) Thaw() error {
+	return subsystem.SetFreezerState(c.Path, subsystem.Thawed)
+}
+
+// ListChildren returns the names of the cgroups below path in any of the
+// subsystems, so leftovers of crashed containers can be found.
+func ListChildren(path string) []string {
+	seen := map[string]bool{}
+	var children []string
+	for _, sub := range subsystem.Subsystems {
+		subsystemPath, err := subsystem.GetCgroupPath(sub.Name(), path, false)
+		if err != nil {
+			continue
+		}
+		infos, err := ioutil.ReadDir(subsystemPath)
+		if err != nil {
+			logrus.Errorf("read %s cgroup dir, err: %v", sub.Name(), err)
+			continue
+		}
+		for _, info := range infos {
+			if info.IsDir() && !seen[info.Name()] {
+				seen[info.Name()] = true
+				children = append(children, info.Name())
+			}
+		}
+	}
+	return children
+}
@@ -30,12 +30,12 @@ func (*CpuSubSystem) Name() string {
 	return "cpu"
 }
 func (c *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
-	subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true)
-	if err != nil {
-		logrus.Errorf("get %s path, err: %v", cgroupPath, err)
-		return err
-	}
-	if res.CpuSet != "" {
+	if res.CpuShare != "" {
+		subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true)
+		if err != nil {
+			logrus.Errorf("get %s path, err: %v", cgroupPath, err)
+			return err
+		}
 		c.apply = true
 		err = ioutil.WriteFile(path.Join(subsystemCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644)
 		if err != nil {
@@ -47,8 +47,11 @@ func (c *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 }
 
 func (c *CpuSubSystem) Remove(cgroupPath string) error {
-	subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true)
+	subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
 	return os.RemoveAll(subsystemCgroupPath)
@@ -30,31 +30,46 @@ func (*CpuSetSubSystem) Name() string {
 }
 
 func (c *CpuSetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
-	subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true)
-	if err != nil {
-		logrus.Errorf("get %s path, err: %v", cgroupPath, err)
-		return err
-	}
 	if res.CpuSet != "" {
+		subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true)
+		if err != nil {
+			logrus.Errorf("get %s path, err: %v", cgroupPath, err)
+			return err
+		}
 		c.apply = true
-		err := ioutil.WriteFile(path.Join(subsystemCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644)
+		err = ioutil.WriteFile(path.Join(subsystemCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644)
 		if err != nil {
 			logrus.Errorf("failed to write file cpuset.cpus, err: %+v", err)
 			return err
 		}
+		// a cpuset cgroup refuses tasks until it has memory nodes as well
+		mems, err := ioutil.ReadFile(path.Join(path.Dir(subsystemCgroupPath), "cpuset.mems"))
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(subsystemCgroupPath, "cpuset.mems"), mems, 0644)
+		}
+		if err != nil {
+			logrus.Errorf("failed to write file cpuset.mems, err: %+v", err)
+			return err
+		}
 	}
 	return nil
 }
 
 func (c *CpuSetSubSystem) Remove(cgroupPath string) error {
-	subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true)
+	subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
 	return os.RemoveAll(subsystemCgroupPath)
 }
 
 func (c *CpuSetSubSystem) Apply(cgroupPath string, pid int) error {
+	if !c.apply {
+		return nil
+	}
 	subsystemCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false)
 	if err != nil {
 		return err
@@ -0,0 +1,200 @@
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package subsystem
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strings"
+	"unsafe"
+)
+
+// the access_type of struct bpf_cgroup_dev_ctx, the device type is in the
+// lower and the access in the upper 16 bits
+const (
+	bpfDevBlock = 1
+	bpfDevChar  = 2
+
+	bpfAccessMknod = 1
+	bpfAccessRead  = 2
+	bpfAccessWrite = 4
+)
+
+// bpfInsn is an instruction of the kernel bpf virtual machine.
+type bpfInsn struct {
+	code uint8
+	regs uint8 // dst in the lower, src in the upper 4 bits
+	off  int16
+	imm  int32
+}
+
+const (
+	opLdxMemW  = 0x61 // dst = *(u32 *)(src + off)
+	opAnd32Imm = 0x54 // dst &= imm
+	opRsh32Imm = 0x74 // dst >>= imm
+	opMov32Reg = 0xbc // dst = src
+	opMov64Imm = 0xb7 // dst = imm
+	opJneImm   = 0x55 // if dst != imm goto pc + off
+	opJneReg   = 0x5d // if dst != src goto pc + off
+	opExit     = 0x95
+)
+
+// bpfLogBytes holds what the verifier says about a rejected program
+const bpfLogBytes = 64 * 1024
+
+func insn(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{code: code, regs: dst | src<<4, off: off, imm: imm}
+}
+
+// compileDeviceFilter turns rules into a program of type
+// BPF_PROG_TYPE_CGROUP_DEVICE. The rules are tested from the last one, the
+// first matching one decides, devices no rule matches are denied.
+func compileDeviceFilter(rules []DeviceRule) ([]bpfInsn, error) {
+	prog := []bpfInsn{
+		insn(opLdxMemW, 2, 1, 0, 0), // r2 = access_type
+		insn(opAnd32Imm, 2, 0, 0, 0xffff),
+		insn(opLdxMemW, 3, 1, 0, 0), // r3 = access
+		insn(opRsh32Imm, 3, 0, 0, 16),
+		insn(opLdxMemW, 4, 1, 4, 0), // r4 = major
+		insn(opLdxMemW, 5, 1, 8, 0), // r5 = minor
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		block, err := ruleBlock(rules[i])
+		if err != nil {
+			return nil, err
+		}
+		prog = append(prog, block...)
+		// a rule for every device decides, the verifier rejects code after it
+		if len(block) == 2 {
+			return prog, nil
+		}
+	}
+	return append(prog, insn(opMov64Imm, 0, 0, 0, 0), insn(opExit, 0, 0, 0, 0)), nil
+}
+
+// ruleBlock returns 1 or 0 when the device matches rule and falls through
+// to the next block otherwise.
+func ruleBlock(rule DeviceRule) ([]bpfInsn, error) {
+	var block []bpfInsn
+	switch rule.Type {
+	case "a":
+	case "b":
+		block = append(block, insn(opJneImm, 2, 0, 0, bpfDevBlock))
+	case "c":
+		block = append(block, insn(opJneImm, 2, 0, 0, bpfDevChar))
+	default:
+		return nil, fmt.Errorf("invalid device type %s", rule.Type)
+	}
+	var access int32
+	for _, c := range rule.Access {
+		switch c {
+		case 'r':
+			access |= bpfAccessRead
+		case 'w':
+			access |= bpfAccessWrite
+		case 'm':
+			access |= bpfAccessMknod
+		default:
+			return nil, fmt.Errorf("invalid device access %s", rule.Access)
+		}
+	}
+	if access != bpfAccessRead|bpfAccessWrite|bpfAccessMknod {
+		// the access asked for has to be a subset of the rule
+		block = append(block,
+			insn(opMov32Reg, 1, 3, 0, 0),
+			insn(opAnd32Imm, 1, 0, 0, access),
+			insn(opJneReg, 1, 3, 0, 0))
+	}
+	if rule.Major >= 0 {
+		block = append(block, insn(opJneImm, 4, 0, 0, int32(rule.Major)))
+	}
+	if rule.Minor >= 0 {
+		block = append(block, insn(opJneImm, 5, 0, 0, int32(rule.Minor)))
+	}
+	result := int32(0)
+	if rule.Allow {
+		result = 1
+	}
+	block = append(block, insn(opMov64Imm, 0, 0, 0, result), insn(opExit, 0, 0, 0, 0))
+	// the jumps skip the rest of the block
+	for i := range block {
+		if block[i].code == opJneImm || block[i].code == opJneReg {
+			block[i].off = int16(len(block) - i - 1)
+		}
+	}
+	return block, nil
+}
+
+// attachDeviceFilter loads the filter of rules and attaches it to the
+// cgroup v2 directory dir. The attachment keeps the program alive.
+func attachDeviceFilter(dir string, rules []DeviceRule) error {
+	prog, err := compileDeviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	code := make([]byte, 0, len(prog)*8)
+	for _, i := range prog {
+		code = append(code, i.code, i.regs, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint16(code[len(code)-6:], uint16(i.off))
+		binary.LittleEndian.PutUint32(code[len(code)-4:], uint32(i.imm))
+	}
+	license := []byte("Apache\x00")
+	logBuf := make([]byte, bpfLogBytes)
+	loadAttr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&loadAttr)), unsafe.Sizeof(loadAttr))
+	if errno != 0 {
+		return fmt.Errorf("load device filter, err: %v: %s", errno, strings.TrimRight(string(logBuf), "\x00"))
+	}
+	defer unix.Close(int(progFd))
+
+	dirFd, err := unix.Open(dir, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirFd)
+	attachAttr := struct {
+		targetFd    uint32
+		attachBpfFd uint32
+		attachType  uint32
+		attachFlags uint32
+	}{
+		targetFd:    uint32(dirFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+		attachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr)); errno != 0 {
+		return fmt.Errorf("attach device filter to %s, err: %v", dir, errno)
+	}
+	return nil
+}
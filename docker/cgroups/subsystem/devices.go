@@ -0,0 +1,169 @@
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package subsystem
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DeviceRule allows or denies access to devices. Type is c, b or a for all
+// devices, a Major or Minor of -1 matches any number and Access is made of
+// r, w and m for mknod. Later rules win over earlier ones.
+type DeviceRule struct {
+	Allow  bool
+	Type   string
+	Major  int64
+	Minor  int64
+	Access string
+}
+
+// String is the rule as devices.allow and devices.deny take it.
+func (r DeviceRule) String() string {
+	number := func(n int64) string {
+		if n < 0 {
+			return "*"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprintf("%s %s:%s %s", r.Type, number(r.Major), number(r.Minor), r.Access)
+}
+
+// DevicesSubSystem restricts the devices a container may open or create.
+// Without the devices controller of cgroup v1 the rules are compiled into
+// a bpf program attached to the cgroup v2 of the container.
+type DevicesSubSystem struct {
+	rules []DeviceRule
+}
+
+func (*DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+func (d *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if len(res.Devices) == 0 {
+		return nil
+	}
+	d.rules = res.Devices
+	if _, err := findCgroupMountPoint(d.Name()); err != nil {
+		// cgroup v2 gets the rules when the process joins
+		return nil
+	}
+	subsystemCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, true)
+	if err != nil {
+		logrus.Errorf("get %s path, err: %v", cgroupPath, err)
+		return err
+	}
+	for _, rule := range res.Devices {
+		file := "devices.deny"
+		if rule.Allow {
+			file = "devices.allow"
+		}
+		if err := ioutil.WriteFile(path.Join(subsystemCgroupPath, file), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("write %s to %s, err: %v", rule, file, err)
+		}
+	}
+	return nil
+}
+
+func (d *DevicesSubSystem) Remove(cgroupPath string) error {
+	if _, err := findCgroupMountPoint(d.Name()); err != nil {
+		unifiedPath, err := findCgroup2MountPoint()
+		if err != nil {
+			return nil
+		}
+		if err := os.Remove(path.Join(unifiedPath, cgroupPath)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	subsystemCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(subsystemCgroupPath)
+}
+
+func (d *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	if len(d.rules) == 0 {
+		return nil
+	}
+	if _, err := findCgroupMountPoint(d.Name()); err != nil {
+		return d.applyUnified(cgroupPath, pid)
+	}
+	subsystemCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	tasksPath := path.Join(subsystemCgroupPath, "tasks")
+	err = ioutil.WriteFile(tasksPath, []byte(strconv.Itoa(pid)), os.ModePerm)
+	if err != nil {
+		logrus.Errorf("write pid to tasks, path: %s, pid: %d, err: %v", tasksPath, pid, err)
+		return err
+	}
+	return nil
+}
+
+// applyUnified moves the process into its cgroup v2 and attaches the device
+// filter there.
+func (d *DevicesSubSystem) applyUnified(cgroupPath string, pid int) error {
+	unifiedPath, err := findCgroup2MountPoint()
+	if err != nil {
+		logrus.Warnf("devices of the container are not restricted, err: %v", err)
+		return nil
+	}
+	dir := path.Join(unifiedPath, cgroupPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	procsPath := path.Join(dir, "cgroup.procs")
+	if err := ioutil.WriteFile(procsPath, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		logrus.Errorf("write pid to cgroup.procs, path: %s, pid: %d, err: %v", procsPath, pid, err)
+		return err
+	}
+	return attachDeviceFilter(dir, d.rules)
+}
+
+// findCgroup2MountPoint finds the unified hierarchy, on its own or next to
+// the v1 controllers.
+func findCgroup2MountPoint() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// the file system type follows the separator of the optional fields
+		fields := strings.Split(scanner.Text(), " - ")
+		if len(fields) == 2 && strings.HasPrefix(fields[1], "cgroup2 ") {
+			if mountFields := strings.Split(fields[0], " "); len(mountFields) > 4 {
+				return mountFields[4], nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("cgroup2 is not mounted")
+}
@@ -0,0 +1,146 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package subsystem
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Frozen = "FROZEN"
+	Thawed = "THAWED"
+)
+
+// FreezerSubSystem has no limits, every container joins it so that it can
+// be paused. Hosts without the freezer run containers which cannot be.
+type FreezerSubSystem struct{}
+
+func (*FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+func (f *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+func (f *FreezerSubSystem) Remove(cgroupPath string) error {
+	subsystemCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(subsystemCgroupPath)
+}
+
+func (f *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+	if _, err := findCgroupMountPoint(f.Name()); err != nil {
+		logrus.Warnf("container can not be paused, err: %v", err)
+		return nil
+	}
+	subsystemCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, true)
+	if err != nil {
+		logrus.Errorf("get %s path, err: %v", cgroupPath, err)
+		return err
+	}
+	tasksPath := path.Join(subsystemCgroupPath, "tasks")
+	err = ioutil.WriteFile(tasksPath, []byte(strconv.Itoa(pid)), os.ModePerm)
+	if err != nil {
+		logrus.Errorf("write pid to tasks, path: %s, pid: %d, err: %v", tasksPath, pid, err)
+		return err
+	}
+	return nil
+}
+
+// ErrNoFreezer is returned when the host has neither the freezer of cgroup
+// v1 nor cgroup.freeze of cgroup v2 for the cgroup.
+var ErrNoFreezer = fmt.Errorf("no freezer for the cgroup")
+
+// SetFreezerState freezes or thaws the processes of a cgroup and waits
+// until the kernel got there. A cgroup left FREEZING by a timeout is thawed
+// again.
+func SetFreezerState(cgroupPath, state string) error {
+	if _, err := findCgroupMountPoint("freezer"); err != nil {
+		return setUnifiedFreezerState(cgroupPath, state)
+	}
+	subsystemCgroupPath, err := GetCgroupPath("freezer", cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("container has no freezer cgroup, err: %v", err)
+	}
+	statePath := path.Join(subsystemCgroupPath, "freezer.state")
+	for i := 0; i < 1000; i++ {
+		if err := ioutil.WriteFile(statePath, []byte(state), 0644); err != nil {
+			return err
+		}
+		current, err := ioutil.ReadFile(statePath)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		// FREEZING until every task stopped
+		time.Sleep(time.Millisecond)
+	}
+	if state == Frozen {
+		_ = ioutil.WriteFile(statePath, []byte(Thawed), 0644)
+	}
+	return fmt.Errorf("cgroup %s did not get %s", cgroupPath, state)
+}
+
+// setUnifiedFreezerState uses cgroup.freeze of cgroup v2, cgroup.events
+// tells when the kernel got there.
+func setUnifiedFreezerState(cgroupPath, state string) error {
+	unifiedPath, err := findCgroup2MountPoint()
+	if err != nil {
+		return ErrNoFreezer
+	}
+	dir := path.Join(unifiedPath, cgroupPath)
+	freezePath := path.Join(dir, "cgroup.freeze")
+	if _, err := os.Stat(freezePath); err != nil {
+		return ErrNoFreezer
+	}
+	want := "0"
+	if state == Frozen {
+		want = "1"
+	}
+	if err := ioutil.WriteFile(freezePath, []byte(want), 0644); err != nil {
+		return err
+	}
+	for i := 0; i < 1000; i++ {
+		events, err := ioutil.ReadFile(path.Join(dir, "cgroup.events"))
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(events), "\n") {
+			if line == "frozen "+want {
+				return nil
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if state == Frozen {
+		_ = ioutil.WriteFile(freezePath, []byte("0"), 0644)
+	}
+	return fmt.Errorf("cgroup %s did not get %s", cgroupPath, state)
+}
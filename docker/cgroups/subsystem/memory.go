@@ -22,6 +22,7 @@ import (
 )
 
 type MemorySubSystem struct {
+	apply bool
 }
 
 func (*MemorySubSystem) Name() string {
@@ -29,13 +30,14 @@ func (*MemorySubSystem) Name() string {
 }
 
 func (m *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
-	subsystemCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, true)
-	if err != nil {
-		logrus.Errorf("get %s path, err: %v", cgroupPath, err)
-		return err
-	}
 	if res.MemoryLimit != "" {
-		err := ioutil.WriteFile(path.Join(subsystemCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644)
+		subsystemCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, true)
+		if err != nil {
+			logrus.Errorf("get %s path, err: %v", cgroupPath, err)
+			return err
+		}
+		m.apply = true
+		err = ioutil.WriteFile(path.Join(subsystemCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644)
 		if err != nil {
 			return err
 		}
@@ -44,14 +46,20 @@ func (m *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 }
 
 func (m *MemorySubSystem) Remove(cgroupPath string) error {
-	subsystemCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, true)
+	subsystemCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, false)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
 	return os.RemoveAll(subsystemCgroupPath)
 }
 
 func (m *MemorySubSystem) Apply(cgroupPath string, pid int) error {
+	if !m.apply {
+		return nil
+	}
 	subsystemCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, false)
 	if err != nil {
 		return err
@@ -19,6 +19,8 @@ type ResourceConfig struct {
 	CpuShare string
 	// cpu num
 	CpuSet string
+	// Devices are the device rules, none leave the devices unrestricted
+	Devices []DeviceRule
 }
 
 type Subsystem interface {
@@ -34,5 +36,7 @@ var (
 		&MemorySubSystem{},
 		&CpuSubSystem{},
 		&CpuSetSubSystem{},
+		&FreezerSubSystem{},
+		&DevicesSubSystem{},
 	}
 )
@@ -16,6 +16,7 @@ package subsystem
 
 import (
 	"bufio"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
 	"path"
@@ -31,6 +32,9 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 	cgroupTotalPath := path.Join(cgroupRootPath, cgroupPath)
 	_, err = os.Stat(cgroupTotalPath)
 	if err != nil && os.IsNotExist(err) {
+		if !autoCreate {
+			return "", err
+		}
 		if err := os.MkdirAll(cgroupTotalPath, 0755); err != nil {
 			return "", err
 		}
@@ -54,5 +58,8 @@ func findCgroupMountPoint(subsystem string) (string, error) {
 			}
 		}
 	}
-	return "", scanner.Err()
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("cgroup subsystem %s is not mounted", subsystem)
 }
@@ -15,22 +15,41 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/go-kinds/docker/archive"
 	"github.com/go-kinds/docker/cgroups/subsystem"
 	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/image"
+	"github.com/go-kinds/docker/runtime"
+	"github.com/go-kinds/docker/shim"
+	"github.com/go-kinds/docker/volume"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"text/template"
 )
 
 var runCommand = cli.Command{
-	Name:  "run",
-	Usage: "Create  a container with namespace and cgroups limit",
+	Name:      "run",
+	Usage:     "Create  a container with namespace and cgroups limit",
+	ArgsUsage: "<image> [command [arg...]] | --rootfs <dir> <command> [arg...]",
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "ti",
 			Usage: "enable tty",
 		},
+		cli.BoolFlag{
+			Name:  "d",
+			Usage: "detach container",
+		},
 		cli.StringFlag{
 			Name:  "m",
 			Usage: "memory limit",
@@ -43,9 +62,17 @@ var runCommand = cli.Command{
 			Name:  "cpuset",
 			Usage: "cpuset limit",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "docker volume",
+			Usage: "bind mount a host path or mount a volume, src:dst[:ro|rw][,propagation], may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs, dst[:size=64m,mode=1777], may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "mount",
+			Usage: "mount type=bind|volume|tmpfs,src=,dst=[,readonly][,bind-propagation=][,tmpfs-size=][,tmpfs-mode=], may be repeated",
 		},
 		cli.StringFlag{
 			Name:  "name",
@@ -53,7 +80,11 @@ var runCommand = cli.Command{
 		},
 		cli.StringSliceFlag{
 			Name:  "e",
-			Usage: "docker env",
+			Usage: "environment variable KEY=VALUE, a bare KEY passes the variable of the caller through, may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read environment variables from a file of KEY=VALUE lines, may be repeated",
 		},
 		cli.StringFlag{
 			Name:  "net",
@@ -63,33 +94,335 @@ var runCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "override the entrypoint of the image, an empty one clears it",
+		},
+		cli.StringFlag{
+			Name:  "workdir, w",
+			Usage: "working directory in the container, defaults to the one of the image",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "user[:group] by name or id, defaults to the user of the image",
+		},
+		cli.StringFlag{
+			Name:  "rootfs",
+			Usage: "run on this root file system directory instead of an image, the arguments are the command",
+		},
+		cli.BoolFlag{
+			Name:  "rootfs-writable",
+			Usage: "let the container write to the --rootfs directory instead of a write layer on top of it",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit",
+			Usage: "resource limit name=soft[:hard], e.g. nofile=65535:65535, may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "sysctl",
+			Usage: "namespaced kernel parameter key=value, e.g. net.core.somaxconn=1024, may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device, host[:container][:permissions] with permissions made of r, w and m, may be repeated",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the root file system read only, --tmpfs and -v mounts stay writable",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "systempaths=unconfined, mask=/path[:/path] or unmask=/path[:/path]|ALL, may be repeated",
+		},
+		cli.StringFlag{
+			Name:  "bundle",
+			Usage: "run the OCI bundle in this directory, the argument is the container name",
+		},
+		hookFlag,
+		hookTimeoutFlag,
 	},
 	Action: func(ctx *cli.Context) error {
+		if bundle := ctx.String("bundle"); bundle != "" {
+			name := ctx.String("name")
+			if name == "" {
+				name = ctx.Args().First()
+			}
+			if err := checkContainerName(name); err != nil {
+				return err
+			}
+			spec, err := runtime.SpecFromBundle(bundle)
+			if err != nil {
+				return err
+			}
+			spec.Name = name
+			if ctx.Bool("ti") {
+				spec.Tty = true
+			}
+			if err := addHooks(ctx, spec); err != nil {
+				return err
+			}
+			if err := applySecurity(ctx, spec); err != nil {
+				return err
+			}
+			return runSpec(spec, ctx.Bool("d"))
+		}
+		if err := checkContainerName(ctx.String("name")); err != nil {
+			return err
+		}
+		rootfs := ctx.String("rootfs")
 		if len(ctx.Args()) < 1 {
-			return fmt.Errorf("missing container args")
+			if rootfs != "" {
+				return fmt.Errorf("missing container command")
+			}
+			return fmt.Errorf("missing image")
+		}
+		env, err := parseEnv(ctx)
+		if err != nil {
+			return err
+		}
+		spec := &runtime.ContainerSpec{
+			Name:     ctx.String("name"),
+			Image:    ctx.Args().First(),
+			Cmd:      ctx.Args().Tail(),
+			Env:      env,
+			Tty:      ctx.Bool("ti"),
+			Cwd:      ctx.String("workdir"),
+			Username: ctx.String("user"),
+			Resources: &subsystem.ResourceConfig{
+				MemoryLimit: ctx.String("m"),
+				CpuSet:      ctx.String("cpuset"),
+				CpuShare:    ctx.String("cpushare"),
+			},
+			Network: ctx.String("net"),
+			Ports:   ctx.StringSlice("p"),
+		}
+		if ctx.IsSet("entrypoint") {
+			spec.Entrypoint = []string{}
+			if entrypoint := ctx.String("entrypoint"); entrypoint != "" {
+				spec.Entrypoint = []string{entrypoint}
+			}
+		}
+		mounts, err := parseMounts(ctx)
+		if err != nil {
+			return err
 		}
-		tty := ctx.Bool("ti")
-		res := &subsystem.ResourceConfig{
-			MemoryLimit: ctx.String("m"),
-			CpuSet:      ctx.String("cpuset"),
-			CpuShare:    ctx.String("cpushare"),
+		spec.Mounts = mounts
+		if err := parseLimits(ctx, spec); err != nil {
+			return err
 		}
+		if rootfs != "" {
+			if err := useRootfs(ctx, spec, rootfs); err != nil {
+				return err
+			}
+		}
+		if err := addHooks(ctx, spec); err != nil {
+			return err
+		}
+		if err := applySecurity(ctx, spec); err != nil {
+			return err
+		}
+		return runSpec(spec, ctx.Bool("d"))
+	},
+}
 
-		var cmdArry []string
-		for _, arg := range ctx.Args() {
-			cmdArry = append(cmdArry, arg)
+// applySecurity applies --read-only and --security-opt of run.
+func applySecurity(ctx *cli.Context, spec *runtime.ContainerSpec) error {
+	if ctx.Bool("read-only") {
+		spec.RootfsReadonly = true
+	}
+	for _, opt := range ctx.StringSlice("security-opt") {
+		if err := runtime.ApplySecurityOpt(spec, opt); err != nil {
+			return err
 		}
-		containerName := ctx.String("name")
-		volume := ctx.String("v")
-		imageName := ctx.Args().Get(0)
-		envs := ctx.StringSlice("e")
-		ports := ctx.StringSlice("p")
-		net := ctx.String("net")
+	}
+	return nil
+}
+
+// parseEnv collects the variables of --env-file and -e, in this order so
+// that -e wins. Bare keys take their value from the environment of the cli.
+func parseEnv(ctx *cli.Context) ([]string, error) {
+	var env []string
+	for _, file := range ctx.StringSlice("env-file") {
+		fileEnv, err := runtime.ReadEnvFile(file, os.LookupEnv)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fileEnv...)
+	}
+	for _, e := range ctx.StringSlice("e") {
+		kv, ok, err := runtime.ParseEnv(e, os.LookupEnv)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			env = append(env, kv)
+		}
+	}
+	return env, nil
+}
+
+// parseMounts collects the mounts of -v, --tmpfs and --mount. Missing host
+// paths of -v are created, as they used to be.
+func parseMounts(ctx *cli.Context) ([]runtime.Mount, error) {
+	var mounts []runtime.Mount
+	for _, volume := range ctx.StringSlice("v") {
+		m, err := runtime.ParseVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(m.Source); os.IsNotExist(err) && m.Type == runtime.MountTypeBind {
+			if err := os.MkdirAll(m.Source, 0755); err != nil {
+				return nil, err
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	for _, tmpfs := range ctx.StringSlice("tmpfs") {
+		m, err := runtime.ParseTmpfs(tmpfs)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	for _, mount := range ctx.StringSlice("mount") {
+		m, err := runtime.ParseMount(mount)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// parseLimits collects the rlimits of --ulimit, the devices of --device and
+// the sysctls of --sysctl.
+func parseLimits(ctx *cli.Context, spec *runtime.ContainerSpec) error {
+	for _, ulimit := range ctx.StringSlice("ulimit") {
+		rlimit, err := runtime.ParseUlimit(ulimit)
+		if err != nil {
+			return err
+		}
+		spec.Rlimits = append(spec.Rlimits, rlimit)
+	}
+	for _, d := range ctx.StringSlice("device") {
+		device, err := runtime.ParseDevice(d)
+		if err != nil {
+			return err
+		}
+		spec.Devices = append(spec.Devices, device)
+	}
+	for _, sysctl := range ctx.StringSlice("sysctl") {
+		key, value, err := runtime.ParseSysctl(sysctl)
+		if err != nil {
+			return err
+		}
+		if spec.Sysctls == nil {
+			spec.Sysctls = map[string]string{}
+		}
+		spec.Sysctls[key] = value
+	}
+	return nil
+}
+
+// useRootfs turns an image spec of run into one running on a root file system
+// directory, which gets the default mounts of image containers.
+func useRootfs(ctx *cli.Context, spec *runtime.ContainerSpec, rootfs string) error {
+	if ctx.IsSet("entrypoint") {
+		return fmt.Errorf("--entrypoint needs an image, not --rootfs")
+	}
+	dir, err := filepath.Abs(rootfs)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("rootfs %s is not a directory", rootfs)
+	}
+	spec.Rootfs = dir
+	spec.RootfsLower = !ctx.Bool("rootfs-writable")
+	spec.Image = ""
+	spec.Cmd = ctx.Args()
+	spec.Mounts = append(runtime.DefaultMounts(), spec.Mounts...)
+	return nil
+}
+
+var hookFlag = cli.StringSliceFlag{
+	Name:  "hook",
+	Usage: "run a command at a stage of the container life cycle, stage=command, stages are prestart, createRuntime, poststart and poststop",
+}
+
+var hookTimeoutFlag = cli.IntFlag{
+	Name:  "hook-timeout",
+	Usage: "seconds after which a hook given by --hook is killed, 0 for no timeout",
+}
 
-		Run(cmdArry, tty, res, containerName, imageName, volume, net, envs, ports)
+// addHooks appends the hooks of the flags to the ones the spec may already
+// have from its bundle.
+func addHooks(ctx *cli.Context, spec *runtime.ContainerSpec) error {
+	for _, h := range ctx.StringSlice("hook") {
+		stage, hook, err := runtime.ParseHook(h, ctx.Int("hook-timeout"))
+		if err != nil {
+			return err
+		}
+		if err := spec.AddHook(stage, hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// checkContainerName rejects a name before anything is set up for the
+// container, an empty name gets the id of the container.
+func checkContainerName(name string) error {
+	if name != "" && !container.ValidName(name) {
+		return fmt.Errorf("invalid container name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+func runSpec(spec *runtime.ContainerSpec, detach bool) error {
+	info, err := dockerRuntime.Create(spec)
+	if err != nil {
+		return err
+	}
+	if detach {
+		if err := dockerRuntime.Start(info.Name); err != nil {
+			return err
+		}
+		fmt.Println(info.Name)
 		return nil
-	},
+	}
+	return runForeground(info.Name, spec.Tty)
+}
+
+// runForeground streams the container until it exits and removes it, unless
+// the user detaches from it.
+func runForeground(name string, tty bool) error {
+	// attach before starting, so no output is missed
+	conn, err := dockerRuntime.Attach(name)
+	if err != nil {
+		_ = dockerRuntime.Delete(name, true)
+		return err
+	}
+	if err := dockerRuntime.Start(name); err != nil {
+		conn.Close()
+		_ = dockerRuntime.Delete(name, true)
+		return err
+	}
+	detachKeys, _ := shim.ParseDetachKeys(shim.DefaultDetachKeys)
+	if err := shim.Stream(conn, tty, detachKeys); err == shim.ErrDetached {
+		return nil
+	}
+	code, err := dockerRuntime.Wait(name)
+	if err != nil {
+		return err
+	}
+	if err := dockerRuntime.Delete(name, false); err != nil {
+		logrus.Errorf("delete container %s, err: %v", name, err)
+	}
+	if code != 0 {
+		return cli.NewExitError("", code)
+	}
+	return nil
 }
 
 var initCommand = cli.Command{
@@ -100,3 +433,945 @@ var initCommand = cli.Command{
 		return container.RunContainerInitProcess()
 	},
 }
+
+var shimCommand = cli.Command{
+	Name:   "shim",
+	Usage:  "Monitor a container and relay its stdio. Do not call it outside",
+	Hidden: true,
+	Action: func(context *cli.Context) error {
+		return runtime.RunShim()
+	},
+}
+
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "Attach to the stdio of a detached container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "detach-keys",
+			Usage: "key sequence for detaching a container",
+			Value: shim.DefaultDetachKeys,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		detachKeys, err := shim.ParseDetachKeys(ctx.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		conn, err := dockerRuntime.Attach(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		if err := shim.Stream(conn, true, detachKeys); err != shim.ErrDetached {
+			return err
+		}
+		return nil
+	},
+}
+
+var psCommand = cli.Command{
+	Name:  "ps",
+	Usage: "List containers",
+	Action: func(ctx *cli.Context) error {
+		infos, err := dockerRuntime.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+		_, _ = fmt.Fprint(w, "ID\tNAME\tIMAGE\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+		for _, info := range infos {
+			status := info.Status
+			if status == container.STOP {
+				status = fmt.Sprintf("%s (%d)", status, info.ExitCode)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				info.Id, info.Name, info.Image, info.Pid, status, info.Command, info.CreateTime)
+		}
+		return w.Flush()
+	},
+}
+
+var execCommand = cli.Command{
+	Name:  "exec",
+	Usage: "Run a command in a running container",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "environment variable KEY=VALUE, a bare KEY passes the variable of the caller through, may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read environment variables from a file of KEY=VALUE lines, may be repeated",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 2 {
+			return fmt.Errorf("missing container name or command")
+		}
+		env, err := parseEnv(ctx)
+		if err != nil {
+			return err
+		}
+		code, err := dockerRuntime.Exec(ctx.Args().Get(0), &runtime.ExecSpec{
+			Cmd:    ctx.Args().Tail(),
+			Env:    env,
+			Stdin:  os.Stdin,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		})
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return cli.NewExitError("", code)
+		}
+		return nil
+	},
+}
+
+var killCommand = cli.Command{
+	Name:  "kill",
+	Usage: "Send a signal to a running container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Usage: "signal name or number",
+			Value: "SIGKILL",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		sig, err := parseSignal(ctx.String("s"))
+		if err != nil {
+			return err
+		}
+		return dockerRuntime.Kill(ctx.Args().Get(0), sig)
+	},
+}
+
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n), nil
+	}
+	s = strings.ToUpper(s)
+	if !strings.HasPrefix(s, "SIG") {
+		s = "SIG" + s
+	}
+	sig := unix.SignalNum(s)
+	if sig == 0 {
+		return 0, fmt.Errorf("invalid signal: %s", s)
+	}
+	return sig, nil
+}
+
+var rmCommand = cli.Command{
+	Name:  "rm",
+	Usage: "Remove stopped containers",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "kill running containers first",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		for _, name := range ctx.Args() {
+			if err := dockerRuntime.Delete(name, ctx.Bool("f")); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// The create, start, state and delete commands follow runc, so tooling which
+// produces OCI bundles can drive go-docker. The container stdio is served by
+// the shim, use attach to connect to it.
+var createCommand = cli.Command{
+	Name:      "create",
+	Usage:     "Create a container from an OCI bundle, start runs its process",
+	ArgsUsage: "<container-id>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Usage: "path to the OCI bundle",
+			Value: ".",
+		},
+		hookFlag,
+		hookTimeoutFlag,
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("create needs exactly one container id")
+		}
+		if err := checkContainerName(ctx.Args().First()); err != nil {
+			return err
+		}
+		spec, err := runtime.SpecFromBundle(ctx.String("bundle"))
+		if err != nil {
+			return err
+		}
+		spec.Name = ctx.Args().First()
+		if err := addHooks(ctx, spec); err != nil {
+			return err
+		}
+		_, err = dockerRuntime.Create(spec)
+		return err
+	},
+}
+
+var startCommand = cli.Command{
+	Name:      "start",
+	Usage:     "Run the process of a created container",
+	ArgsUsage: "<container-id>",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("start needs exactly one container id")
+		}
+		return dockerRuntime.Start(ctx.Args().First())
+	},
+}
+
+var stateCommand = cli.Command{
+	Name:      "state",
+	Usage:     "Output the OCI state of a container",
+	ArgsUsage: "<container-id>",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("state needs exactly one container id")
+		}
+		state, err := dockerRuntime.State(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		content, err := json.MarshalIndent(state, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	},
+}
+
+var deleteCommand = cli.Command{
+	Name:      "delete",
+	Usage:     "Delete a stopped or created container",
+	ArgsUsage: "<container-id>",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "kill the container if it is still running",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("delete needs exactly one container id")
+		}
+		return dockerRuntime.Delete(ctx.Args().First(), ctx.Bool("force"))
+	},
+}
+
+var commitCommand = cli.Command{
+	Name:      "commit",
+	Usage:     "Create an image from the changes of a container",
+	ArgsUsage: "<container> [repository[:tag]]",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "change, c",
+			Usage: "apply a CMD, ENTRYPOINT, ENV, WORKDIR, USER, LABEL or EXPOSE instruction to the image",
+		},
+		cli.StringFlag{
+			Name:  "author, a",
+			Usage: "author of the image",
+		},
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "commit message",
+		},
+		cli.BoolTFlag{
+			Name:  "pause, p",
+			Usage: "pause the container while committing",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 || len(ctx.Args()) > 2 {
+			return fmt.Errorf("commit needs a container and optionally an image name")
+		}
+		img, err := dockerRuntime.Commit(ctx.Args().Get(0), ctx.Args().Get(1), runtime.CommitOptions{
+			Changes: ctx.StringSlice("change"),
+			Author:  ctx.String("author"),
+			Message: ctx.String("message"),
+			Pause:   ctx.BoolT("pause"),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(img.ID)
+		return nil
+	},
+}
+
+var exportCommand = cli.Command{
+	Name:      "export",
+	Usage:     "Export the file system of a container as a tar",
+	ArgsUsage: "<container>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "file to write instead of stdout",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("export needs exactly one container")
+		}
+		output := ctx.String("output")
+		if output == "" {
+			if _, err := unix.IoctlGetTermios(int(os.Stdout.Fd()), unix.TCGETS); err == nil {
+				return fmt.Errorf("refusing to write a tar to a terminal, use -o or redirect stdout")
+			}
+			return dockerRuntime.Export(ctx.Args().First(), os.Stdout)
+		}
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		err = dockerRuntime.Export(ctx.Args().First(), f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(output)
+		}
+		return err
+	},
+}
+
+var diffCommand = cli.Command{
+	Name:      "diff",
+	Usage:     "List the files a container added (A), changed (C) or deleted (D)",
+	ArgsUsage: "<container>",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "output the changes as json",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return fmt.Errorf("diff needs exactly one container")
+		}
+		changes, err := dockerRuntime.Diff(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		if ctx.Bool("json") {
+			if changes == nil {
+				changes = []archive.Change{}
+			}
+			content, err := json.MarshalIndent(changes, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(content))
+			return nil
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+		return nil
+	},
+}
+
+var importCommand = cli.Command{
+	Name:      "import",
+	Usage:     "Create a single layer image from a root file system tar",
+	ArgsUsage: "<file|-> [repository[:tag]]",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "change, c",
+			Usage: "apply a CMD, ENTRYPOINT, ENV, WORKDIR, USER, LABEL or EXPOSE instruction to the image",
+		},
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "commit message",
+		},
+	},
+	Action: importImage,
+}
+
+var buildCommand = cli.Command{
+	Name:      "build",
+	Usage:     "Build an image from a Dockerfile",
+	ArgsUsage: "[context]",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "tag, t",
+			Usage: "name[:tag] of the image, may be repeated",
+		},
+		cli.StringFlag{
+			Name:  "file, f",
+			Usage: "Dockerfile to build, defaults to the Dockerfile in the context",
+		},
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "set an ARG of the Dockerfile, KEY=VALUE or KEY to take the value from the environment",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cached layers",
+		},
+	},
+	Action: buildImage,
+}
+
+var imagesCommand = cli.Command{
+	Name:   "images",
+	Usage:  "List images",
+	Action: listImages,
+}
+
+var rmiCommand = cli.Command{
+	Name:      "rmi",
+	Usage:     "Remove images",
+	ArgsUsage: "<image>...",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "f",
+			Usage: "remove all tags of the image and images of stopped containers",
+		},
+	},
+	Action: removeImages,
+}
+
+var tagCommand = cli.Command{
+	Name:      "tag",
+	Usage:     "Add a tag to an image",
+	ArgsUsage: "<image> <name[:tag]>",
+	Action:    tagImage,
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "Load images from a docker save archive or an OCI image layout tar",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i",
+			Usage: "archive to read instead of stdin",
+		},
+	},
+	Action: loadImages,
+}
+
+var pullCommand = cli.Command{
+	Name:      "pull",
+	Usage:     "Pull an image from a registry",
+	ArgsUsage: "<[registry/]repository[:tag|@digest]>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "platform",
+			Usage: "os/arch of the image, e.g. linux/arm64, defaults to the host",
+		},
+	},
+	Action: pullImage,
+}
+
+var pushCommand = cli.Command{
+	Name:      "push",
+	Usage:     "Push an image to a registry",
+	ArgsUsage: "<[registry/]repository[:tag]>",
+	Action:    pushImage,
+}
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "Manage images",
+	Subcommands: []cli.Command{
+		{
+			Name:   "ls",
+			Usage:  imagesCommand.Usage,
+			Action: listImages,
+		},
+		{
+			Name:      "rm",
+			Usage:     rmiCommand.Usage,
+			ArgsUsage: rmiCommand.ArgsUsage,
+			Flags:     rmiCommand.Flags,
+			Action:    removeImages,
+		},
+		{
+			Name:      "tag",
+			Usage:     tagCommand.Usage,
+			ArgsUsage: tagCommand.ArgsUsage,
+			Action:    tagImage,
+		},
+		{
+			Name:   "load",
+			Usage:  loadCommand.Usage,
+			Flags:  loadCommand.Flags,
+			Action: loadImages,
+		},
+		{
+			Name:      "import",
+			Usage:     importCommand.Usage,
+			ArgsUsage: importCommand.ArgsUsage,
+			Flags:     importCommand.Flags,
+			Action:    importImage,
+		},
+		{
+			Name:      "build",
+			Usage:     buildCommand.Usage,
+			ArgsUsage: buildCommand.ArgsUsage,
+			Flags:     buildCommand.Flags,
+			Action:    buildImage,
+		},
+		{
+			Name:      "pull",
+			Usage:     pullCommand.Usage,
+			ArgsUsage: pullCommand.ArgsUsage,
+			Flags:     pullCommand.Flags,
+			Action:    pullImage,
+		},
+		{
+			Name:      "push",
+			Usage:     pushCommand.Usage,
+			ArgsUsage: pushCommand.ArgsUsage,
+			Action:    pushImage,
+		},
+		{
+			Name:      "inspect",
+			Usage:     "Show the details of images as json",
+			ArgsUsage: "<image>...",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing image name")
+				}
+				var images []*image.Image
+				for _, ref := range ctx.Args() {
+					img, err := dockerRuntime.ImageStore().Get(ref)
+					if err != nil {
+						return err
+					}
+					images = append(images, img)
+				}
+				content, err := json.MarshalIndent(images, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(content))
+				return nil
+			},
+		},
+	},
+}
+
+func listImages(ctx *cli.Context) error {
+	images, err := dockerRuntime.ListImages()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, img := range images {
+		id := strings.TrimPrefix(img.ID, "sha256:")[:12]
+		created := img.Created.Local().Format("2006-01-02 15:04:05")
+		tags := img.RepoTags
+		if len(tags) == 0 {
+			tags = []string{"<none>:<none>"}
+		}
+		for _, ref := range tags {
+			repo, tag := image.SplitReference(ref)
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", repo, tag, id, created, humanSize(img.Size))
+		}
+	}
+	return w.Flush()
+}
+
+func loadImages(ctx *cli.Context) error {
+	tarStream := os.Stdin
+	if input := ctx.String("i"); input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tarStream = f
+	}
+	results, err := dockerRuntime.LoadImages(tarStream)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if len(result.Refs) == 0 {
+			fmt.Printf("Loaded image ID: %s\n", result.Image.ID)
+		}
+		for _, ref := range result.Refs {
+			fmt.Printf("Loaded image: %s\n", ref)
+		}
+	}
+	return nil
+}
+
+func importImage(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 || len(ctx.Args()) > 2 {
+		return fmt.Errorf("import needs a tar file and optionally an image name")
+	}
+	source := ctx.Args().Get(0)
+	tarStream := os.Stdin
+	if source != "-" {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tarStream = f
+		source = path.Base(source)
+	}
+	img, err := dockerRuntime.ImportImage(tarStream, source, ctx.Args().Get(1), runtime.ImportOptions{
+		Changes: ctx.StringSlice("change"),
+		Message: ctx.String("message"),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(img.ID)
+	return nil
+}
+
+func buildImage(ctx *cli.Context) error {
+	if len(ctx.Args()) > 1 {
+		return fmt.Errorf("build needs at most one context directory")
+	}
+	contextDir := ctx.Args().First()
+	if contextDir == "" {
+		contextDir = "."
+	}
+	buildArgs := map[string]string{}
+	for _, arg := range ctx.StringSlice("build-arg") {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) == 2 {
+			buildArgs[kv[0]] = kv[1]
+		} else if value, ok := os.LookupEnv(kv[0]); ok {
+			buildArgs[kv[0]] = value
+		}
+	}
+	_, err := dockerRuntime.Build(runtime.BuildOptions{
+		Dockerfile: ctx.String("file"),
+		Context:    contextDir,
+		Tags:       ctx.StringSlice("tag"),
+		BuildArgs:  buildArgs,
+		NoCache:    ctx.Bool("no-cache"),
+		Progress:   os.Stdout,
+	})
+	return err
+}
+
+func pullImage(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("pull needs exactly one image")
+	}
+	_, err := dockerRuntime.PullImage(ctx.Args().First(), runtime.PullOptions{
+		Platform: ctx.String("platform"),
+		Progress: os.Stdout,
+	})
+	return err
+}
+
+func pushImage(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("push needs exactly one image")
+	}
+	_, err := dockerRuntime.PushImage(ctx.Args().First(), runtime.PushOptions{Progress: os.Stdout})
+	return err
+}
+
+func removeImages(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("missing image name")
+	}
+	for _, ref := range ctx.Args() {
+		result, err := dockerRuntime.RemoveImage(ref, ctx.Bool("f"))
+		if err != nil {
+			return err
+		}
+		for _, line := range result {
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
+
+func tagImage(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return fmt.Errorf("tag needs an image and a new name")
+	}
+	return dockerRuntime.TagImage(ctx.Args().Get(0), ctx.Args().Get(1))
+}
+
+func humanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
+
+var volumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "Manage volumes",
+	Subcommands: []cli.Command{
+		{
+			Name:      "create",
+			Usage:     "Create a volume",
+			ArgsUsage: "[name]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "driver, d",
+					Value: volume.Local,
+					Usage: "driver of the volume",
+				},
+				cli.StringSliceFlag{
+					Name:  "opt, o",
+					Usage: "driver option key=value, may be repeated",
+				},
+				cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "label key=value, may be repeated",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) > 1 {
+					return fmt.Errorf("volume create takes at most one name")
+				}
+				options, err := keyValueFlags(ctx.StringSlice("opt"))
+				if err != nil {
+					return err
+				}
+				labels, err := keyValueFlags(ctx.StringSlice("label"))
+				if err != nil {
+					return err
+				}
+				v, err := dockerRuntime.CreateVolume(ctx.Args().First(), runtime.VolumeOptions{
+					Driver:  ctx.String("driver"),
+					Options: options,
+					Labels:  labels,
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Println(v.Name)
+				return nil
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "List volumes",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "quiet, q",
+					Usage: "only list the names",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				volumes, err := dockerRuntime.ListVolumes()
+				if err != nil {
+					return err
+				}
+				if ctx.Bool("quiet") {
+					for _, v := range volumes {
+						fmt.Println(v.Name)
+					}
+					return nil
+				}
+				w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+				_, _ = fmt.Fprint(w, "DRIVER\tVOLUME NAME\n")
+				for _, v := range volumes {
+					_, _ = fmt.Fprintf(w, "%s\t%s\n", v.Driver, v.Name)
+				}
+				return w.Flush()
+			},
+		},
+		{
+			Name:      "inspect",
+			Usage:     "Show the details of volumes as json",
+			ArgsUsage: "<volume>...",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				var volumes []*volume.Volume
+				for _, name := range ctx.Args() {
+					v, err := dockerRuntime.GetVolume(name)
+					if err != nil {
+						return err
+					}
+					volumes = append(volumes, v)
+				}
+				content, err := json.MarshalIndent(volumes, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(content))
+				return nil
+			},
+		},
+		{
+			Name:      "rm",
+			Usage:     "Remove volumes no container uses",
+			ArgsUsage: "<volume>...",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing volume name")
+				}
+				for _, name := range ctx.Args() {
+					if err := dockerRuntime.RemoveVolume(name); err != nil {
+						return err
+					}
+					fmt.Println(name)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "Remove all volumes no container uses",
+			Action: func(ctx *cli.Context) error {
+				removed, err := dockerRuntime.PruneVolumes()
+				for _, name := range removed {
+					fmt.Println(name)
+				}
+				return err
+			},
+		},
+	},
+}
+
+// keyValueFlags reads key=value flags into a map, a key without value maps
+// to an empty string.
+func keyValueFlags(flags []string) (map[string]string, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	values := map[string]string{}
+	for _, flag := range flags {
+		kv := strings.SplitN(flag, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid %s, should be key=value", flag)
+		}
+		if len(kv) == 2 {
+			values[kv[0]] = kv[1]
+		} else {
+			values[kv[0]] = ""
+		}
+	}
+	return values, nil
+}
+
+var inspectCommand = cli.Command{
+	Name:      "inspect",
+	Usage:     "Show the details of containers, images, volumes or networks",
+	ArgsUsage: "<name>...",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "only look for a container, image, volume or network",
+		},
+		cli.StringFlag{
+			Name:  "format, f",
+			Usage: "format each object with a go template, e.g. '{{.State.Pid}}'",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing name")
+		}
+		var tmpl *template.Template
+		if format := ctx.String("format"); format != "" {
+			var err error
+			tmpl, err = template.New("inspect").Funcs(templateFuncs).Parse(format)
+			if err != nil {
+				return fmt.Errorf("parse format %s, err: %v", format, err)
+			}
+		}
+		var objects []interface{}
+		for _, name := range ctx.Args() {
+			object, err := dockerRuntime.Inspect(ctx.String("type"), name)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, object)
+		}
+		if tmpl == nil {
+			content, err := json.MarshalIndent(objects, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(content))
+			return nil
+		}
+		for _, object := range objects {
+			if err := tmpl.Execute(os.Stdout, object); err != nil {
+				return fmt.Errorf("format %s, err: %v", ctx.Args().First(), err)
+			}
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		content, err := json.Marshal(v)
+		return string(content), err
+	},
+	"join":  strings.Join,
+	"split": strings.Split,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+var systemCommand = cli.Command{
+	Name:  "system",
+	Usage: "Manage go-docker",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "Remove stopped containers and resources leaked by crashed runs",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only list what would be removed",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				dryRun := ctx.Bool("dry-run")
+				orphans, pruneErr := dockerRuntime.Prune(dryRun)
+				w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+				_, _ = fmt.Fprint(w, "TYPE\tNAME\tRESULT\n")
+				for _, orphan := range orphans {
+					result := "removed"
+					if dryRun {
+						result = "would remove"
+					} else if orphan.Err != nil {
+						result = fmt.Sprintf("failed: %v", orphan.Err)
+					}
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", orphan.Kind, orphan.Name, result)
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				return pruneErr
+			},
+		},
+	},
+}
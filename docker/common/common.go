@@ -15,12 +15,22 @@
 package common
 
 const (
-	RootPath   = "/root/"
-	MntPath    = "/root/mnt"
 	WriteLayer = "writeLayer"
+	MntDir     = "mnt"
+	ImageDir   = "image"
+	VolumeDir  = "volumes"
 )
 
 const (
-	DefaultNetworkPath   = "/var/run/go-docker/network/network/"
-	DefaultAllocatorPath = "/var/run/go-docker/network/ipam/subnet.json"
+	ContainerDir  = "containers"
+	NetworkDir    = "network/network"
+	AllocatorFile = "network/ipam/subnet.json"
+)
+
+const (
+	ConfigName       = "config.json"
+	ContainerLogFile = "container.log"
+	ShimLogFile      = "shim.log"
+	AttachSocket     = "attach.sock"
+	ControlSocket    = "control.sock"
 )
@@ -0,0 +1,111 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package common
+
+import (
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	DefaultRootPath     = "/root/"
+	DefaultStateDir     = "/var/run/go-docker"
+	DefaultCgroupParent = "go-docker"
+	DefaultConfigFile   = "/etc/go-docker/config.yaml"
+	// ConfigFileEnv overrides the location of the config file
+	ConfigFileEnv = "GO_DOCKER_CONFIG"
+)
+
+// Config holds the locations the runtime works in. Root keeps images, write
+// layers and mount points, StateDir keeps the container records and the
+// network state. Every package gets its paths from here, so several runtimes
+// can live side by side on one host. An empty StorageDriver is filled in
+// with the driver the kernel supports. Registry logins are read from
+// CredentialsFile, by default the config.json of docker.
+type Config struct {
+	Root               string   `yaml:"root" json:"root"`
+	StateDir           string   `yaml:"state-dir" json:"state_dir"`
+	CgroupParent       string   `yaml:"cgroup-parent" json:"cgroup_parent"`
+	StorageDriver      string   `yaml:"storage-driver" json:"storage_driver"`
+	CredentialsFile    string   `yaml:"credentials-file" json:"credentials_file"`
+	InsecureRegistries []string `yaml:"insecure-registries" json:"insecure_registries"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Root:         DefaultRootPath,
+		StateDir:     DefaultStateDir,
+		CgroupParent: DefaultCgroupParent,
+	}
+}
+
+// LoadConfig reads the yaml config file on top of the defaults. An empty
+// configPath falls back to $GO_DOCKER_CONFIG and then to the default file,
+// which may be missing.
+func LoadConfig(configPath string) (*Config, error) {
+	cfg := DefaultConfig()
+	explicit := true
+	if configPath == "" {
+		configPath = os.Getenv(ConfigFileEnv)
+	}
+	if configPath == "" {
+		configPath = DefaultConfigFile
+		explicit = false
+	}
+	bs, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(bs, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ImagePath is per storage driver, layers are unpacked in the whiteout
+// format of the driver.
+func (c *Config) ImagePath() string {
+	return path.Join(c.Root, ImageDir, c.StorageDriver)
+}
+
+func (c *Config) MntPath() string {
+	return path.Join(c.Root, MntDir)
+}
+
+func (c *Config) WriteLayerPath() string {
+	return path.Join(c.Root, WriteLayer)
+}
+
+// VolumePath keeps the named volumes, each in a directory of its name.
+func (c *Config) VolumePath() string {
+	return path.Join(c.Root, VolumeDir)
+}
+
+func (c *Config) ContainerPath() string {
+	return path.Join(c.StateDir, ContainerDir)
+}
+
+func (c *Config) NetworkPath() string {
+	return path.Join(c.StateDir, NetworkDir)
+}
+
+func (c *Config) AllocatorPath() string {
+	return path.Join(c.StateDir, AllocatorFile)
+}
@@ -0,0 +1,25 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package common
+
+// Orphan is a leftover resource of a container which is gone, Remove
+// releases it.
+type Orphan struct {
+	Kind   string
+	Name   string
+	Remove func() error
+	// Err is set when Remove failed
+	Err error
+}
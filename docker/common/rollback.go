@@ -0,0 +1,59 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package common
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+type undoStep struct {
+	name string
+	undo func() error
+}
+
+// Rollback records an undo action for every finished setup step, so a
+// failure half way can unwind everything done so far in reverse order.
+type Rollback struct {
+	steps []undoStep
+}
+
+func NewRollback() *Rollback {
+	return &Rollback{}
+}
+
+// Add registers the undo action of a step that just succeeded.
+func (r *Rollback) Add(name string, undo func() error) {
+	r.steps = append(r.steps, undoStep{name: name, undo: undo})
+}
+
+// Unwind runs every registered undo action in reverse order. A failing undo
+// is logged and does not stop the remaining ones.
+func (r *Rollback) Unwind() {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		if err := step.undo(); err != nil {
+			logrus.Errorf("undo %s, err: %v", step.name, err)
+		}
+	}
+	r.steps = nil
+}
+
+// UnwindOnError unwinds when *errp is not nil, it is meant to be deferred by
+// functions with a named error result.
+func (r *Rollback) UnwindOnError(errp *error) {
+	if *errp != nil {
+		r.Unwind()
+	}
+}
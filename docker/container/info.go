@@ -14,13 +14,163 @@
 
 package container
 
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/common"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	CREATED = "created"
+	RUNNING = "running"
+	STOP    = "stopped"
+)
+
 type ContainerInfo struct {
 	Pid         string   `json:"pid"`
+	MonitorPid  string   `json:"monitor_pid"`
 	Id          string   `json:"id"`
 	Command     string   `json:"command"`
 	Name        string   `json:"name"`
+	Image       string   `json:"image"`
+	ImageID     string   `json:"image_id,omitempty"`
 	CreateTime  string   `json:"create_time"`
 	Status      string   `json:"status"`
-	Volume      string   `json:"volume"`
+	ExitCode    int      `json:"exit_code"`
 	PortMapping []string `json:"port_mapping"`
+	Network     string   `json:"network"`
+	IPAddress   string   `json:"ip_address"`
+	MacAddress  string   `json:"mac_address,omitempty"`
+	Tty         bool     `json:"tty"`
+	// Bundle is set for containers created from an OCI bundle
+	Bundle string `json:"bundle,omitempty"`
+	// Mounts are the bind mounts, volumes and tmpfs the container was
+	// created with
+	Mounts []MountPoint `json:"mounts,omitempty"`
+	// Env is the environment the command of the container started with
+	Env []string `json:"env,omitempty"`
+}
+
+// MountPoint describes a mount of a container for its record. Name is the
+// name of a volume, Source the host path of binds and volumes.
+type MountPoint struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	ReadOnly    bool   `json:"read_only"`
+	Propagation string `json:"propagation,omitempty"`
+}
+
+// IsAlive reports whether the container or the process monitoring it, the
+// shim or the foreground cli, is still around.
+func (info *ContainerInfo) IsAlive() bool {
+	return processExists(info.Pid) || processExists(info.MonitorPid)
+}
+
+func processExists(pid string) bool {
+	n, err := strconv.Atoi(pid)
+	if err != nil || n <= 0 {
+		return false
+	}
+	err = syscall.Kill(n, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+func RandStringBytes(n int) string {
+	letterBytes := "1234567890"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return string(b)
+}
+
+// ContainerDir returns the state directory of a container, it holds the
+// config.json, the log file and the attach socket.
+// validName is what names a container, the name is part of the paths of its
+// record and its layers.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidName tells whether name may name a container.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+func ContainerDir(cfg *common.Config, containerName string) string {
+	return path.Join(cfg.ContainerPath(), containerName)
+}
+
+func RecordContainerInfo(cfg *common.Config, info *ContainerInfo) error {
+	dir := ContainerDir(cfg, info.Name)
+	if _, err := os.Stat(dir); err != nil && os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	bs, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// write to a temp file first so readers never see a half written config
+	tmpPath := path.Join(dir, common.ConfigName+".tmp")
+	if err := ioutil.WriteFile(tmpPath, bs, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path.Join(dir, common.ConfigName))
+}
+
+func GetContainerInfo(cfg *common.Config, containerName string) (*ContainerInfo, error) {
+	bs, err := ioutil.ReadFile(path.Join(ContainerDir(cfg, containerName), common.ConfigName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such container: %s", containerName)
+		}
+		return nil, err
+	}
+	info := &ContainerInfo{}
+	if err := json.Unmarshal(bs, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func ListContainerInfos(cfg *common.Config) ([]*ContainerInfo, error) {
+	dirs, err := ioutil.ReadDir(cfg.ContainerPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var infos []*ContainerInfo
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		info, err := GetContainerInfo(cfg, dir.Name())
+		if err != nil {
+			logrus.Errorf("get container %s info, err: %v", dir.Name(), err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func DeleteContainerInfo(cfg *common.Config, containerName string) error {
+	return os.RemoveAll(ContainerDir(cfg, containerName))
 }
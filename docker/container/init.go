@@ -15,49 +15,147 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 	"syscall"
 )
 
+// InitConfig is sent to the init process over its pipe once the container
+// is set up, the init process prepares itself accordingly and executes Args.
+type InitConfig struct {
+	Args []string `json:"args"`
+	// Env replaces the inherited environment when set
+	Env []string `json:"env,omitempty"`
+	// Rootfs is pivoted into when set, otherwise the process keeps the file
+	// system it was started in
+	Rootfs         string  `json:"rootfs,omitempty"`
+	RootfsReadonly bool    `json:"rootfs_readonly,omitempty"`
+	Mounts         []Mount `json:"mounts,omitempty"`
+	Hostname       string  `json:"hostname,omitempty"`
+	Cwd            string  `json:"cwd,omitempty"`
+	User           *User   `json:"user,omitempty"`
+	// Username is resolved in the root file system when User is not set, see
+	// LookupUser
+	Username string   `json:"username,omitempty"`
+	Rlimits  []Rlimit `json:"rlimits,omitempty"`
+	// Sysctls are written below /proc/sys, after /proc of the container is
+	// mounted
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+	// MaskedPaths are hidden and ReadonlyPaths remounted read only once the
+	// process pivoted into Rootfs
+	MaskedPaths   []string `json:"masked_paths,omitempty"`
+	ReadonlyPaths []string `json:"readonly_paths,omitempty"`
+	// Devices are created in /dev of Rootfs
+	Devices []Device `json:"devices,omitempty"`
+}
+
+type User struct {
+	Uid            uint32   `json:"uid"`
+	Gid            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additional_gids,omitempty"`
+}
+
+// Device is a device node of a container. Permissions are what the devices
+// cgroup grants, made of r, w and m.
+type Device struct {
+	Path        string      `json:"path"`
+	Type        string      `json:"type"`
+	Major       int64       `json:"major"`
+	Minor       int64       `json:"minor"`
+	FileMode    os.FileMode `json:"file_mode"`
+	Uid         uint32      `json:"uid"`
+	Gid         uint32      `json:"gid"`
+	Permissions string      `json:"permissions,omitempty"`
+}
+
+type Rlimit struct {
+	// Type is the name of the resource, e.g. RLIMIT_NOFILE
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
+	conf, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if len(conf.Args) == 0 {
 		return fmt.Errorf("get user command in run container")
 	}
-	err := setUpMount()
+	if conf.Env != nil {
+		os.Clearenv()
+		for _, kv := range conf.Env {
+			if i := strings.Index(kv, "="); i > 0 {
+				_ = os.Setenv(kv[:i], kv[i+1:])
+			}
+		}
+	}
+
+	if conf.Rootfs == "" {
+		if err = setUpMount(); err == nil {
+			err = writeSysctls(conf.Sysctls)
+		}
+	} else {
+		err = setUpRootfs(conf)
+	}
 	if err != nil {
 		logrus.Errorf("set up mount, err: %v", err)
 		return err
 	}
+	if conf.Hostname != "" {
+		if err := syscall.Sethostname([]byte(conf.Hostname)); err != nil {
+			return fmt.Errorf("set hostname, err: %v", err)
+		}
+	}
+	if err := setRlimits(conf.Rlimits); err != nil {
+		return err
+	}
+	user := conf.User
+	if user == nil && conf.Username != "" {
+		if user, err = LookupUser(conf.Username); err != nil {
+			return err
+		}
+	}
+	if err := setUser(user); err != nil {
+		return err
+	}
+	if conf.Cwd != "" {
+		if err := os.Chdir(conf.Cwd); err != nil {
+			return fmt.Errorf("change to working directory %s, err: %v", conf.Cwd, err)
+		}
+	}
 
-	path, err := exec.LookPath(cmdArray[0])
+	path, err := exec.LookPath(conf.Args[0])
 	if err != nil {
-		logrus.Errorf("look %s path, err: %v", cmdArray[0], err)
+		logrus.Errorf("look %s path, err: %v", conf.Args[0], err)
 		return err
 	}
 
-	err = syscall.Exec(path, cmdArray[0:], os.Environ())
+	err = syscall.Exec(path, conf.Args, os.Environ())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func readUserCommand() []string {
+func readInitConfig() (*InitConfig, error) {
 	// cmd.ExtraFiles readPipe
 	pipe := os.NewFile(uintptr(3), "pipe")
-	bs, err := ioutil.ReadAll(pipe)
-	if err != nil {
+	defer pipe.Close()
+	conf := &InitConfig{}
+	if err := json.NewDecoder(pipe).Decode(conf); err != nil {
 		logrus.Errorf("read pipe, err : %v", err)
-		return nil
+		return nil, err
 	}
-	msg := string(bs)
-	return strings.Split(msg, " ")
+	return conf, nil
 }
 
 func setUpMount() error {
@@ -74,3 +172,98 @@ func setUpMount() error {
 	}
 	return nil
 }
+
+var rlimitTypes = map[string]int{
+	"RLIMIT_AS":         unix.RLIMIT_AS,
+	"RLIMIT_CORE":       unix.RLIMIT_CORE,
+	"RLIMIT_CPU":        unix.RLIMIT_CPU,
+	"RLIMIT_DATA":       unix.RLIMIT_DATA,
+	"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+	"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+	"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+	"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+	"RLIMIT_NICE":       unix.RLIMIT_NICE,
+	"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+	"RLIMIT_RSS":        unix.RLIMIT_RSS,
+	"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+	"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+	"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+	"RLIMIT_STACK":      unix.RLIMIT_STACK,
+}
+
+// ValidateRlimit reports unknown resource types before the container is
+// created.
+func ValidateRlimit(rlimit Rlimit) error {
+	if _, ok := rlimitTypes[rlimit.Type]; !ok {
+		return fmt.Errorf("unknown rlimit type: %s", rlimit.Type)
+	}
+	if rlimit.Soft > rlimit.Hard {
+		return fmt.Errorf("soft limit of %s exceeds the hard limit", rlimit.Type)
+	}
+	return nil
+}
+
+// writeSysctls sets kernel parameters of the namespaces of the process.
+func writeSysctls(sysctls map[string]string) error {
+	for key, value := range sysctls {
+		file := path.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
+		if !namespacedSysctlFile(file) {
+			return fmt.Errorf("sysctl %s is not namespaced", key)
+		}
+		if err := ioutil.WriteFile(file, []byte(value), 0644); err != nil {
+			return fmt.Errorf("set sysctl %s, err: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// namespacedSysctlFile tells whether file is below one of the /proc/sys
+// directories holding namespaced sysctls, the key was validated by the
+// runtime already.
+func namespacedSysctlFile(file string) bool {
+	if strings.Contains(file, "..") {
+		return false
+	}
+	for _, dir := range []string{"/proc/sys/net/", "/proc/sys/kernel/", "/proc/sys/fs/mqueue/"} {
+		if strings.HasPrefix(file, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// setRlimits runs before the user is switched, so hard limits can be raised.
+func setRlimits(rlimits []Rlimit) error {
+	for _, rlimit := range rlimits {
+		resource, ok := rlimitTypes[rlimit.Type]
+		if !ok {
+			return fmt.Errorf("unknown rlimit type: %s", rlimit.Type)
+		}
+		if err := unix.Setrlimit(resource, &unix.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}); err != nil {
+			return fmt.Errorf("set %s, err: %v", rlimit.Type, err)
+		}
+	}
+	return nil
+}
+
+func setUser(user *User) error {
+	if user == nil {
+		return nil
+	}
+	groups := make([]int, 0, len(user.AdditionalGids))
+	for _, gid := range user.AdditionalGids {
+		groups = append(groups, int(gid))
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("set additional groups, err: %v", err)
+	}
+	if err := syscall.Setgid(int(user.Gid)); err != nil {
+		return fmt.Errorf("set gid %d, err: %v", user.Gid, err)
+	}
+	// the uid goes last, it drops the privileges needed for the rest
+	if err := syscall.Setuid(int(user.Uid)); err != nil {
+		return fmt.Errorf("set uid %d, err: %v", user.Uid, err)
+	}
+	return nil
+}
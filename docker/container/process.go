@@ -15,31 +15,31 @@
 package container
 
 import (
-	"github.com/go-kinds/docker/common"
-	"github.com/sirupsen/logrus"
 	"os"
 	"os/exec"
 	"syscall"
 )
 
-func NewParentProcess(tty bool, volume, containerName, imageName string, envs []string) (*exec.Cmd, *os.File) {
-	readPipe, writePipe, _ := os.Pipe()
+// DefaultCloneFlags are the namespaces a container gets unless told
+// otherwise.
+const DefaultCloneFlags = syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC
+
+// NewParentProcess builds the init process of a container, started in dir
+// with the namespaces of cloneFlags and nothing but envs as environment.
+// The returned pipe is used to send the InitConfig once the container is
+// set up. The caller hooks up the stdio.
+func NewParentProcess(dir string, cloneFlags uintptr, envs []string) (*exec.Cmd, *os.File, error) {
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
 	cmd := exec.Command("/proc/self/exe", "init")
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
-	}
-	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		Cloneflags: cloneFlags,
 	}
-	cmd.Env = append(os.Environ(), envs...)
+	// the environment of the host, secrets included, stays out
+	cmd.Env = append([]string{}, envs...)
 	cmd.ExtraFiles = []*os.File{readPipe}
-
-	err := NewWorkSpace(volume, containerName, imageName)
-	if err != nil {
-		logrus.Errorf("new work space, err : %v", err)
-	}
-	cmd.Dir = common.MntPath
-	return cmd, writePipe
+	cmd.Dir = dir
+	return cmd, writePipe, nil
 }
@@ -0,0 +1,349 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package container
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// Mount is mounted into the root file system of a container, Options are
+// fstab style.
+type Mount struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+var mountFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"defaults":    {false, 0},
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"sync":        {false, syscall.MS_SYNCHRONOUS},
+	"async":       {true, syscall.MS_SYNCHRONOUS},
+	"dirsync":     {false, syscall.MS_DIRSYNC},
+	"mand":        {false, syscall.MS_MANDLOCK},
+	"nomand":      {true, syscall.MS_MANDLOCK},
+	"atime":       {true, syscall.MS_NOATIME},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"diratime":    {true, syscall.MS_NODIRATIME},
+	"nodiratime":  {false, syscall.MS_NODIRATIME},
+	"relatime":    {false, syscall.MS_RELATIME},
+	"norelatime":  {true, syscall.MS_RELATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+	"bind":        {false, syscall.MS_BIND},
+	"rbind":       {false, syscall.MS_BIND | syscall.MS_REC},
+}
+
+var propagationFlags = map[string]uintptr{
+	"private":     syscall.MS_PRIVATE,
+	"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":      syscall.MS_SHARED,
+	"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+	"slave":       syscall.MS_SLAVE,
+	"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+	"unbindable":  syscall.MS_UNBINDABLE,
+	"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+}
+
+// parseMountOptions splits fstab style options into mount flags, the
+// propagation to apply afterwards and the file system specific data.
+func parseMountOptions(options []string) (flags, propagation uintptr, data string) {
+	var extra []string
+	for _, o := range options {
+		if f, ok := mountFlags[o]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+			continue
+		}
+		if p, ok := propagationFlags[o]; ok {
+			propagation |= p
+			continue
+		}
+		extra = append(extra, o)
+	}
+	return flags, propagation, strings.Join(extra, ",")
+}
+
+// setUpRootfs mounts everything into the root file system and pivots into
+// it. It runs in the init process, inside the new mount namespace.
+func setUpRootfs(conf *InitConfig) error {
+	rootfs := conf.Rootfs
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("make / private, err: %v", err)
+	}
+	// pivot_root needs the new root to be a mount point
+	if err := syscall.Mount(rootfs, rootfs, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mount rootfs, err: %v", err)
+	}
+	for _, m := range conf.Mounts {
+		if err := mountInto(rootfs, m); err != nil {
+			return fmt.Errorf("mount %s, err: %v", m.Destination, err)
+		}
+	}
+	if err := createDevices(rootfs); err != nil {
+		return err
+	}
+	for _, device := range conf.Devices {
+		if err := createDeviceNode(rootfs, device); err != nil {
+			return fmt.Errorf("create device %s, err: %v", device.Path, err)
+		}
+	}
+	if err := pivotRoot(rootfs); err != nil {
+		return err
+	}
+	// the working directory of an image may not exist yet
+	if conf.Cwd != "" {
+		if err := os.MkdirAll(conf.Cwd, 0755); err != nil {
+			return fmt.Errorf("create working directory %s, err: %v", conf.Cwd, err)
+		}
+	}
+	for _, p := range conf.MaskedPaths {
+		if err := maskPath(p); err != nil {
+			return fmt.Errorf("mask %s, err: %v", p, err)
+		}
+	}
+	// /proc/sys is still writable
+	if err := writeSysctls(conf.Sysctls); err != nil {
+		return err
+	}
+	for _, p := range conf.ReadonlyPaths {
+		if err := readonlyPath(p); err != nil {
+			return fmt.Errorf("remount %s read only, err: %v", p, err)
+		}
+	}
+	if conf.RootfsReadonly {
+		if err := syscall.Mount("", "/", "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("remount rootfs read only, err: %v", err)
+		}
+	}
+	return nil
+}
+
+// maskPath hides a file behind /dev/null and a directory behind an empty
+// read only tmpfs. Paths the kernel doesn't have are skipped.
+func maskPath(p string) error {
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return syscall.Mount("tmpfs", p, "tmpfs", syscall.MS_RDONLY, "size=0k")
+	}
+	return syscall.Mount("/dev/null", p, "", syscall.MS_BIND, "")
+}
+
+// readonlyPath binds a path onto itself to remount it read only.
+func readonlyPath(p string) error {
+	if err := syscall.Mount(p, p, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return syscall.Mount("", p, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+}
+
+// mountInto mounts m at its destination in rootfs, symlinks of the image
+// are resolved as if rootfs was the root already.
+func mountInto(rootfs string, m Mount) error {
+	dest, err := archive.ResolveInRoot(rootfs, m.Destination)
+	if err != nil {
+		return err
+	}
+	flags, propagation, data := parseMountOptions(m.Options)
+	if m.Type == "bind" {
+		flags |= syscall.MS_BIND
+	}
+
+	if m.Type == "cgroup" {
+		// the cgroup hierarchies of the host are not mounted into containers
+		logrus.Warnf("skip cgroup mount %s", m.Destination)
+		return nil
+	}
+
+	if flags&syscall.MS_BIND != 0 {
+		if err := createMountTarget(m.Source, dest); err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.Source, dest, "", flags&(syscall.MS_BIND|syscall.MS_REC), ""); err != nil {
+			return err
+		}
+		// flags other than bind are ignored by the initial bind mount
+		if rest := flags &^ (syscall.MS_BIND | syscall.MS_REC); rest != 0 {
+			if err := syscall.Mount("", dest, "", syscall.MS_BIND|syscall.MS_REMOUNT|rest, ""); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.Source, dest, m.Type, flags, data); err != nil {
+			return err
+		}
+	}
+
+	if propagation != 0 {
+		if err := syscall.Mount("", dest, "", propagation, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createMountTarget creates the mount point of a bind mount, a file for
+// files and a directory otherwise.
+func createMountTarget(source, dest string) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return os.MkdirAll(dest, 0755)
+	}
+	if err := os.MkdirAll(path.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+var defaultDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom", "/dev/tty"}
+
+// createDevices binds the default devices of the host into /dev of the
+// container, if the container has a /dev without them, and adds the usual
+// links.
+func createDevices(rootfs string) error {
+	dev, err := archive.ResolveInRoot(rootfs, "/dev")
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(dev); err != nil || !fi.IsDir() {
+		return nil
+	}
+	for _, device := range defaultDevices {
+		dest := path.Join(dev, path.Base(device))
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+		if err := createMountTarget(device, dest); err != nil {
+			return fmt.Errorf("create %s, err: %v", device, err)
+		}
+		if err := syscall.Mount(device, dest, "", syscall.MS_BIND, ""); err != nil {
+			return fmt.Errorf("bind %s, err: %v", device, err)
+		}
+	}
+	links := map[string]string{
+		"/dev/fd":     "/proc/self/fd",
+		"/dev/stdin":  "/proc/self/fd/0",
+		"/dev/stdout": "/proc/self/fd/1",
+		"/dev/stderr": "/proc/self/fd/2",
+	}
+	if ptmx, err := archive.ResolveInRoot(rootfs, "/dev/pts/ptmx"); err == nil {
+		if _, err := os.Stat(ptmx); err == nil {
+			links["/dev/ptmx"] = "pts/ptmx"
+		}
+	}
+	for link, target := range links {
+		dest := path.Join(dev, path.Base(link))
+		if _, err := os.Lstat(dest); os.IsNotExist(err) {
+			if err := os.Symlink(target, dest); err != nil {
+				return fmt.Errorf("create %s, err: %v", link, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createDeviceNode replaces whatever is at the path of device in rootfs by
+// the device node, which has to stay below /dev of the container.
+func createDeviceNode(rootfs string, device Device) error {
+	parent, err := archive.ResolveInRoot(rootfs, path.Dir(device.Path))
+	if err != nil {
+		return err
+	}
+	dest := path.Join(parent, path.Base(device.Path))
+	if !strings.HasPrefix(dest, path.Join(rootfs, "dev")+"/") {
+		return fmt.Errorf("%s resolves to %s, which is not below %s/dev", device.Path, dest, rootfs)
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mode := uint32(device.FileMode.Perm())
+	switch device.Type {
+	case "c":
+		mode |= unix.S_IFCHR
+	case "b":
+		mode |= unix.S_IFBLK
+	default:
+		return fmt.Errorf("invalid device type %s", device.Type)
+	}
+	if err := unix.Mknod(dest, mode, int(unix.Mkdev(uint32(device.Major), uint32(device.Minor)))); err != nil {
+		return err
+	}
+	// mknod applies the umask
+	if err := os.Chmod(dest, device.FileMode.Perm()); err != nil {
+		return err
+	}
+	return os.Chown(dest, int(device.Uid), int(device.Gid))
+}
+
+func pivotRoot(rootfs string) error {
+	pivotDir := path.Join(rootfs, ".pivot_root")
+	if err := os.MkdirAll(pivotDir, 0700); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(rootfs, pivotDir); err != nil {
+		return fmt.Errorf("pivot_root, err: %v", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	// the old root is still mounted below the new one, drop it
+	pivotDir = "/.pivot_root"
+	if err := syscall.Unmount(pivotDir, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root, err: %v", err)
+	}
+	return os.Remove(pivotDir)
+}
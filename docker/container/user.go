@@ -0,0 +1,145 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-kinds/docker/archive"
+)
+
+const (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// LookupUser resolves user[:group] of an image config in the passwd and
+// group files of the current root. Numeric ids need no entry, a uid without
+// entry is in group 0. A user without group gets its primary group and the
+// groups it is a member of.
+func LookupUser(spec string) (*User, error) {
+	name, group := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+	user := &User{}
+	passwd, _ := readColonFile(passwdFile)
+	uid, err := strconv.ParseUint(name, 10, 32)
+	found := false
+	for _, entry := range passwd {
+		if len(entry) < 4 || (entry[0] != name && (err != nil || entry[2] != name)) {
+			continue
+		}
+		entryUid, uerr := strconv.ParseUint(entry[2], 10, 32)
+		entryGid, gerr := strconv.ParseUint(entry[3], 10, 32)
+		if uerr != nil || gerr != nil {
+			continue
+		}
+		user.Uid, user.Gid, found = uint32(entryUid), uint32(entryGid), true
+		name = entry[0]
+		break
+	}
+	if !found {
+		if err != nil {
+			return nil, fmt.Errorf("no user %s in %s", name, passwdFile)
+		}
+		// like runc, a uid without entry is in the root group
+		user.Uid, user.Gid = uint32(uid), 0
+	}
+
+	groups, _ := readColonFile(groupFile)
+	if group != "" {
+		gid, err := strconv.ParseUint(group, 10, 32)
+		if err != nil {
+			gid, err = lookupGroup(groups, group)
+			if err != nil {
+				return nil, err
+			}
+		}
+		user.Gid = uint32(gid)
+		return user, nil
+	}
+	for _, entry := range groups {
+		if len(entry) < 4 {
+			continue
+		}
+		for _, member := range strings.Split(entry[3], ",") {
+			if member != name {
+				continue
+			}
+			if gid, err := strconv.ParseUint(entry[2], 10, 32); err == nil && uint32(gid) != user.Gid {
+				user.AdditionalGids = append(user.AdditionalGids, uint32(gid))
+			}
+		}
+	}
+	return user, nil
+}
+
+// LookupHome returns the home directory of user, a name or uid with an
+// optional group, in the passwd file of the root file system at root. It is
+// / for users without entry.
+func LookupHome(root, user string) string {
+	name := strings.SplitN(user, ":", 2)[0]
+	if name == "" {
+		name = "0"
+	}
+	// symlinks of an image must not lead out of it
+	passwdPath, err := archive.ResolveInRoot(root, passwdFile)
+	if err != nil {
+		return "/"
+	}
+	if fi, err := os.Lstat(passwdPath); err != nil || !fi.Mode().IsRegular() {
+		return "/"
+	}
+	passwd, _ := readColonFile(passwdPath)
+	for _, entry := range passwd {
+		if len(entry) >= 6 && (entry[0] == name || entry[2] == name) && entry[5] != "" {
+			return entry[5]
+		}
+	}
+	return "/"
+}
+
+func lookupGroup(groups [][]string, name string) (uint64, error) {
+	for _, entry := range groups {
+		if len(entry) >= 3 && entry[0] == name {
+			return strconv.ParseUint(entry[2], 10, 32)
+		}
+	}
+	return 0, fmt.Errorf("no group %s in %s", name, groupFile)
+}
+
+// readColonFile splits the lines of passwd style files into their fields.
+func readColonFile(filePath string) ([][]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, scanner.Err()
+}
@@ -16,72 +16,73 @@
 package container
 
 import (
+	"bufio"
 	"fmt"
 	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/storage"
 	"github.com/sirupsen/logrus"
-	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
+	"sort"
 	"strings"
+	"syscall"
 )
 
-func NewWorkSpace(volume, containerName, imageName string) error {
-	err := createReadOnlyLayer(imageName)
-	if err != nil {
-		logrus.Errorf("create read only layer, err :%v", err)
-		return err
-	}
+const (
+	writeLayerDiffDir = "diff"
+	writeLayerWorkDir = "work"
+)
 
-	err = createWriteLayer(containerName)
+// NewWorkSpace mounts a writable layer on top of the read only image layers,
+// given top layer first.
+func NewWorkSpace(cfg *common.Config, containerName string, layerDirs []string) (err error) {
+	rb := common.NewRollback()
+	defer rb.UnwindOnError(&err)
+
+	err = createWriteLayer(cfg, containerName)
 	if err != nil {
 		logrus.Errorf("create write layer, err: %v", err)
 		return err
 	}
+	rb.Add("write layer", func() error {
+		return deleteWriteLayer(cfg, containerName)
+	})
 
-	err = CreateMountPoint(containerName, imageName)
+	err = CreateMountPoint(cfg, containerName, layerDirs)
 	if err != nil {
 		logrus.Errorf("create mount point, err: %v", err)
 		return err
 	}
-	mountVolume(containerName, imagename, volume)
 	return nil
 }
 
-func createReadOnlyLayer(imageName string) error {
-	imagePath := path.Join(common.RootPath, imageName)
-	_, err := os.Stat(imagePath)
-	if err != nil && os.IsNotExist(err) {
-		err := os.MkdirAll(imagePath, os.ModePerm)
-		if err != nil {
-			logrus.Errorf("mkdir image path, err: %v", err)
+// createWriteLayer creates the upper directory of the container and the
+// work directory some storage drivers need next to it.
+func createWriteLayer(cfg *common.Config, containerName string) error {
+	writeLayerPath := path.Join(cfg.WriteLayerPath(), containerName)
+	for _, dir := range []string{writeLayerDiffDir, writeLayerWorkDir} {
+		if err := os.MkdirAll(path.Join(writeLayerPath, dir), 0755); err != nil {
+			logrus.Errorf("mkdir write layer, err: %v", err)
 			return err
 		}
 	}
-	imageTarPath := path.Join(common.RootPath, fmt.Sprintf("%s.tar", imageName))
-	if _, err = exec.Command("tar", "-xvf", imageTarPath, "-C", imagePath).CombinedOutput(); err != nil {
-		logrus.Errorf("tar image tar,path: %s, err: %v", imageTarPath, err)
-		return err
-	}
 	return nil
 }
 
-func createWriteLayer(containerName string) error {
-	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
-	_, err := os.Stat(writeLayerPath)
-	if err != nil && os.IsNotExist(err) {
-		err = os.MkdirAll(writeLayerPath, os.ModePerm)
-		if err != nil {
-			logrus.Errorf("mkdir write layer, err: %v", err)
-			return err
-		}
-	}
-	return nil
+// WriteLayerDiff is the directory holding what a container changed on top
+// of its image.
+func WriteLayerDiff(cfg *common.Config, containerName string) string {
+	return path.Join(cfg.WriteLayerPath(), containerName, writeLayerDiffDir)
 }
 
-func CreateMountPoint(containerName, imageName string) error {
-	mntPath := path.Join(common.MntPath, containerName)
-	_, err := os.Stat(mntPath)
+func CreateMountPoint(cfg *common.Config, containerName string, layerDirs []string) error {
+	driver, err := storage.Get(cfg.StorageDriver)
+	if err != nil {
+		return err
+	}
+	mntPath := path.Join(cfg.MntPath(), containerName)
+	_, err = os.Stat(mntPath)
 	if err != nil && os.IsNotExist(err) {
 		err := os.MkdirAll(mntPath, os.ModePerm)
 		if err != nil {
@@ -90,65 +91,64 @@ func CreateMountPoint(containerName, imageName string) error {
 		}
 	}
 
-	writeLayPath := path.Join(common.RootPath, common.WriteLayer, containerName)
-	imagePath := path.Join(common.RootPath, imageName)
-	dirs := fmt.Sprintf("dirs=%s:%s", writeLayPath, imagePath)
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "node", mntPath)
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("mnt cmd run, err: %v", err)
+	writeLayerPath := path.Join(cfg.WriteLayerPath(), containerName)
+	upperDir := path.Join(writeLayerPath, writeLayerDiffDir)
+	workDir := path.Join(writeLayerPath, writeLayerWorkDir)
+	if err := driver.Mount(layerDirs, upperDir, workDir, mntPath); err != nil {
+		logrus.Errorf("mount %s, err: %v", driver.Name(), err)
+		_ = os.Remove(mntPath)
 		return err
 	}
 	return nil
 }
 
-func mountVolume(containerName, imageName, volume string) {
-	if volume != "" {
-		volumes := strings.Split(volume, ":")
-		if len(volumes) > 1 {
-			parentPath := volumes[0]
-			if _, err := os.Stat(parentPath); err != nil && os.IsNotExist(err) {
-				if err := os.MkdirAll(parentPath, os.ModePerm); err != nil {
-					logrus.Errorf("mkdir parent path: %s, err: %v", parentPath, err)
-				}
-			}
-
-			containerPath := volumes[1]
-			containerVolumePath := path.Join(common.MntPath, containerName, containerPath)
-			if _, err := os.Stat(containerVolumePath); err != nil && os.IsNotExist(err) {
-				if err := os.MkdirAll(containerVolumePath, os.ModePerm); err != nil {
-					logrus.Errorf("mkdir volume path path: %s, err: %v", containerVolumePath, err)
-				}
-			}
-
-			dirs := fmt.Sprintf("dirs=%s", parentPath)
-			cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumePath)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				logrus.Errorf("mount cmd run, err: %v", err)
-			}
-		}
+// MountWriteLayer mounts the write layer of a stopped container on top of
+// its layers at target, so its file system can be read without starting
+// it. The work directory is its own, the returned function unmounts target
+// and removes it again.
+func MountWriteLayer(cfg *common.Config, containerName string, layerDirs []string, target string) (func() error, error) {
+	driver, err := storage.Get(cfg.StorageDriver)
+	if err != nil {
+		return nil, err
 	}
-}
-
-func DeleteWorkSpace(containerName, volume string) error {
-	err := unMountPoint(containerName)
+	writeLayerPath := path.Join(cfg.WriteLayerPath(), containerName)
+	// overlay wants the work directory on the file system of the upper one
+	workDir, err := ioutil.TempDir(writeLayerPath, "mount-")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	upperDir := path.Join(writeLayerPath, writeLayerDiffDir)
+	if err := driver.Mount(layerDirs, upperDir, workDir, target); err != nil {
+		_ = os.RemoveAll(workDir)
+		return nil, err
+	}
+	return func() error {
+		if err := unmount(target); err != nil {
+			return err
+		}
+		return os.RemoveAll(workDir)
+	}, nil
+}
 
-	err = deleteWriteLayer(containerName)
-	if err != nil {
+// DeleteWorkSpace tears down whatever part of the work space exists, so it
+// is safe to call on a half created one.
+func DeleteWorkSpace(cfg *common.Config, containerName string) error {
+	if err := UnmountWorkSpace(cfg, containerName); err != nil {
 		return err
 	}
+	return deleteWriteLayer(cfg, containerName)
+}
 
-	deleteVolume(containerName, volume)
-	return nil
+// UnmountWorkSpace removes the mount point and keeps the write layer, what
+// the container changed can still be read from it. Mounts of the container
+// live in its mount namespace and are gone with it.
+func UnmountWorkSpace(cfg *common.Config, containerName string) error {
+	return unMountPoint(cfg, containerName)
 }
 
-func unMountPoint(containerName string) error {
-	mntPath := path.Join(common.MntPath, containerName)
-	if _, err := exec.Command("umount", mntPath).CombinedOutput(); err != nil {
+func unMountPoint(cfg *common.Config, containerName string) error {
+	mntPath := path.Join(cfg.MntPath(), containerName)
+	if err := unmount(mntPath); err != nil {
 		logrus.Errorf("umount mnt, err : %v", err)
 		return err
 	}
@@ -160,21 +160,91 @@ func unMountPoint(containerName string) error {
 	return nil
 }
 
-func deleteWriteLayer(containerName string) error {
-	writeLayerPath := path.Join(common.RootPath, common.WriteLayer, containerName)
+// unmount ignores targets that are missing or not mounted at all.
+func unmount(target string) error {
+	err := syscall.Unmount(target, 0)
+	if err == syscall.EINVAL || err == syscall.ENOENT {
+		return nil
+	}
+	return err
+}
+
+func deleteWriteLayer(cfg *common.Config, containerName string) error {
+	writeLayerPath := path.Join(cfg.WriteLayerPath(), containerName)
 	return os.RemoveAll(writeLayerPath)
 }
 
-func deleteVolume(containerName, volume string) error {
-	if volume != "" {
-		volumes := strings.Split(volume, ":")
-		if len(volumes) > 1 {
-			mntPath := path.Join(common.MntPath, containerName)
-			containerPath := path.Join(mntPath, volumes[1])
-			if _, err := exec.Command("umount", containerPath).CombinedOutput(); err != nil {
-				logrus.Errorf("umount container path, err: %v", err)
-			}
+// ListWorkSpaces returns the container names owning a mount point and the
+// ones owning a write layer.
+func ListWorkSpaces(cfg *common.Config) ([]string, []string, error) {
+	mountPoints, err := listDirNames(cfg.MntPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	writeLayers, err := listDirNames(cfg.WriteLayerPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	return mountPoints, writeLayers, nil
+}
+
+func listDirNames(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
 		}
+		return nil, err
 	}
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
 
+// RemoveMountPoint unmounts everything below the mount point of a container,
+// deepest first, and removes it. It is used for leftovers whose volume
+// configuration is no longer known.
+func RemoveMountPoint(cfg *common.Config, containerName string) error {
+	mntPath := path.Join(cfg.MntPath(), containerName)
+	mounts, err := mountsUnder(mntPath)
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(mounts)))
+	for _, target := range mounts {
+		if err := unmount(target); err != nil {
+			return fmt.Errorf("umount %s, err: %v", target, err)
+		}
+	}
+	return os.RemoveAll(mntPath)
+}
+
+// RemoveWriteLayer deletes the write layer of a container.
+func RemoveWriteLayer(cfg *common.Config, containerName string) error {
+	return deleteWriteLayer(cfg, containerName)
+}
+
+func mountsUnder(dir string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) < 5 {
+			continue
+		}
+		target := fields[4]
+		if target == dir || strings.HasPrefix(target, dir+"/") {
+			mounts = append(mounts, target)
+		}
+	}
+	return mounts, scanner.Err()
 }
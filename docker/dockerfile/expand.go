@@ -0,0 +1,101 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package dockerfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expand replaces $NAME, ${NAME}, ${NAME:-default} and ${NAME:+alternative}
+// with what lookup finds. Nothing is replaced in single quotes and \$ is a
+// plain dollar sign.
+func Expand(s string, lookup func(name string) (string, bool)) (string, error) {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+			b.WriteByte(c)
+		case c == '\\' && i+1 < len(s) && s[i+1] == '$':
+			b.WriteByte('$')
+			i++
+		case c != '$' || quoted || i+1 == len(s):
+			b.WriteByte(c)
+		case s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("missing } in %q", s)
+			}
+			value, err := expandBraces(s[i+2:i+end], lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += end
+		default:
+			end := i + 1
+			for end < len(s) && isNameChar(s[end], end == i+1) {
+				end++
+			}
+			if end == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			value, _ := lookup(s[i+1 : end])
+			b.WriteString(value)
+			i = end - 1
+		}
+	}
+	return b.String(), nil
+}
+
+func expandBraces(expr string, lookup func(name string) (string, bool)) (string, error) {
+	name, word, op := expr, "", ""
+	if i := strings.Index(expr, ":"); i >= 0 {
+		if i+2 > len(expr) {
+			return "", fmt.Errorf("bad substitution ${%s}", expr)
+		}
+		name, op, word = expr[:i], expr[i:i+2], expr[i+2:]
+	}
+	if name == "" {
+		return "", fmt.Errorf("bad substitution ${%s}", expr)
+	}
+	value, ok := lookup(name)
+	switch op {
+	case "":
+		return value, nil
+	case ":-":
+		if !ok || value == "" {
+			return word, nil
+		}
+		return value, nil
+	case ":+":
+		if ok && value != "" {
+			return word, nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported substitution ${%s}", expr)
+}
+
+func isNameChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
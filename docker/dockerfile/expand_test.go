@@ -0,0 +1,63 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package dockerfile
+
+import (
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"NAME": "app", "EMPTY": "", "DIR": "/srv"}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{s: "$NAME", want: "app"},
+		{s: "${NAME}.py", want: "app.py"},
+		{s: "$DIR/$NAME", want: "/srv/app"},
+		{s: "$NAME_x", want: ""},
+		{s: "$UNSET", want: ""},
+		{s: "${UNSET:-default}", want: "default"},
+		{s: "${EMPTY:-default}", want: "default"},
+		{s: "${NAME:-default}", want: "app"},
+		{s: "${NAME:+set}", want: "set"},
+		{s: "${EMPTY:+set}", want: ""},
+		{s: "'$NAME'", want: "'$NAME'"},
+		{s: `\$NAME`, want: "$NAME"},
+		{s: "cost $5 $", want: "cost $5 $"},
+		{s: "${NAME", wantErr: true},
+		{s: "${}", wantErr: true},
+		{s: "${:-x}", wantErr: true},
+		{s: "${NAME:}", wantErr: true},
+		{s: "${NAME:?error}", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Expand(tt.s, lookup)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expand(%s) = %q, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Expand(%s) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
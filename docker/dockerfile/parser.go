@@ -0,0 +1,166 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package dockerfile parses the subset of the Dockerfile syntax go-docker
+// builds images from.
+package dockerfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Instruction is one instruction of a Dockerfile with its line continuations
+// joined.
+type Instruction struct {
+	// Command is upper case, e.g. RUN
+	Command string
+	// Flags are the leading --name=value arguments
+	Flags map[string]string
+	// Args is the rest of the line
+	Args string
+	// Original is the instruction as written, for messages and the history
+	Original string
+	Line     int
+}
+
+// Parse reads the instructions of a Dockerfile. Comments, empty lines and
+// backslash continued lines are understood, parser directives are not.
+func Parse(r io.Reader) ([]Instruction, error) {
+	var instructions []Instruction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var current strings.Builder
+	start, lineNo := 0, 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimLeftFunc(scanner.Text(), unicode.IsSpace)
+		// comments may sit between continued lines as well
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if current.Len() == 0 {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			start = lineNo
+		}
+		trimmed := strings.TrimRightFunc(line, unicode.IsSpace)
+		if strings.HasSuffix(trimmed, `\`) {
+			current.WriteString(strings.TrimSuffix(trimmed, `\`))
+			continue
+		}
+		current.WriteString(line)
+		instruction, err := parseInstruction(current.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current.Len() > 0 {
+		instruction, err := parseInstruction(current.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+	return instructions, nil
+}
+
+func parseInstruction(text string, line int) (Instruction, error) {
+	text = strings.TrimSpace(text)
+	fields := strings.SplitN(text, " ", 2)
+	instruction := Instruction{
+		Command:  strings.ToUpper(fields[0]),
+		Flags:    map[string]string{},
+		Original: text,
+		Line:     line,
+	}
+	rest := ""
+	if len(fields) > 1 {
+		rest = strings.TrimSpace(fields[1])
+	}
+	for strings.HasPrefix(rest, "--") {
+		fields := strings.SplitN(rest, " ", 2)
+		flag := strings.SplitN(strings.TrimPrefix(fields[0], "--"), "=", 2)
+		if len(flag) != 2 || flag[0] == "" {
+			return instruction, fmt.Errorf("line %d: invalid flag %s, should be --name=value", line, fields[0])
+		}
+		instruction.Flags[flag[0]] = flag[1]
+		rest = ""
+		if len(fields) > 1 {
+			rest = strings.TrimSpace(fields[1])
+		}
+	}
+	instruction.Args = rest
+	return instruction, nil
+}
+
+// JSONArgs returns the arguments of the exec form ["a", "b"], ok is false
+// for the shell form.
+func (i Instruction) JSONArgs() ([]string, bool) {
+	if !strings.HasPrefix(i.Args, "[") {
+		return nil, false
+	}
+	var args []string
+	if err := json.Unmarshal([]byte(i.Args), &args); err != nil {
+		return nil, false
+	}
+	return args, true
+}
+
+// Words splits the arguments of the shell form at white space, double
+// quotes group words.
+func Words(args string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case c == '\\' && i+1 < len(args):
+			i++
+			word.WriteByte(args[i])
+			inWord = true
+		case c == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (c == ' ' || c == '\t'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", args)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,125 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package dockerfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		want       []Instruction
+	}{
+		{
+			name:       "comments and empty lines",
+			dockerfile: "# syntax\n\nfrom scratch\n  # indented\nCMD [\"sh\"]\n",
+			want: []Instruction{
+				{Command: "FROM", Flags: map[string]string{}, Args: "scratch", Original: "from scratch", Line: 3},
+				{Command: "CMD", Flags: map[string]string{}, Args: `["sh"]`, Original: `CMD ["sh"]`, Line: 5},
+			},
+		},
+		{
+			name:       "continued lines",
+			dockerfile: "RUN a \\\n# between\n  && b \\\n  && c\nENV A=1",
+			want: []Instruction{
+				{Command: "RUN", Flags: map[string]string{}, Args: "a && b && c", Original: "RUN a && b && c", Line: 1},
+				{Command: "ENV", Flags: map[string]string{}, Args: "A=1", Original: "ENV A=1", Line: 5},
+			},
+		},
+		{
+			name:       "continued last line",
+			dockerfile: "WORKDIR /app \\",
+			want: []Instruction{
+				{Command: "WORKDIR", Flags: map[string]string{}, Args: "/app", Original: "WORKDIR /app", Line: 1},
+			},
+		},
+		{
+			name:       "flags",
+			dockerfile: "COPY --chown=1:2 --from=build a b",
+			want: []Instruction{
+				{Command: "COPY", Flags: map[string]string{"chown": "1:2", "from": "build"}, Args: "a b", Original: "COPY --chown=1:2 --from=build a b", Line: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.dockerfile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRejectsInvalidFlags(t *testing.T) {
+	for _, dockerfile := range []string{"COPY --chown a b", "COPY --=1 a b"} {
+		if _, err := Parse(strings.NewReader(dockerfile)); err == nil {
+			t.Errorf("%q is parsed", dockerfile)
+		}
+	}
+}
+
+func TestJSONArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+		ok   bool
+	}{
+		{args: `["sh", "-c", "echo hi"]`, want: []string{"sh", "-c", "echo hi"}, ok: true},
+		{args: `[]`, want: []string{}, ok: true},
+		{args: `echo hi`},
+		{args: `[not json`},
+	}
+	for _, tt := range tests {
+		got, ok := Instruction{Args: tt.args}.JSONArgs()
+		if ok != tt.ok || (ok && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("JSONArgs(%s) = %q, %v, want %q, %v", tt.args, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    []string
+		wantErr bool
+	}{
+		{args: "a  b\tc", want: []string{"a", "b", "c"}},
+		{args: `"a b" c`, want: []string{"a b", "c"}},
+		{args: `a\ b "c\"d"`, want: []string{"a b", `c"d`}},
+		{args: `""`, want: []string{""}},
+		{args: "", want: nil},
+		{args: `"a b`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Words(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Words(%s) = %q, want an error", tt.args, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Words(%s) = %q, %v, want %q", tt.args, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// CacheKey hashes what a build step depends on into a key of the build
+// cache.
+func CacheKey(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// CachedLayer returns the layer a build step with the same key made before.
+// Layers removed since are cache misses.
+func (s *Store) CachedLayer(key string) (*Layer, bool) {
+	content, err := ioutil.ReadFile(path.Join(s.root, buildCacheDir, key))
+	if err != nil {
+		return nil, false
+	}
+	diffID := strings.TrimSpace(string(content))
+	layer, err := s.GetLayer(diffID)
+	if err != nil {
+		_ = os.Remove(path.Join(s.root, buildCacheDir, key))
+		return nil, false
+	}
+	return layer, true
+}
+
+// CacheLayer records the layer a build step made.
+func (s *Store) CacheLayer(key, diffID string) error {
+	if err := os.MkdirAll(path.Join(s.root, buildCacheDir), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path.Join(s.root, buildCacheDir, key), []byte(diffID))
+}
+
+// BuildOwner references the layers of a build while it runs, the images its
+// RUN steps start containers from come and go meanwhile.
+func BuildOwner(buildID string) string {
+	return "build:" + buildID
+}
+
+// EndBuild drops the references of a build. Unlike ReleaseLayers it keeps
+// the layers left without references, the build cache points to them until
+// prune removes them.
+func (s *Store) EndBuild(owner string, diffIDs []string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.dropReferences(leaseOwner(), diffIDs, false); err != nil {
+		return err
+	}
+	return s.dropReferences(owner, diffIDs, false)
+}
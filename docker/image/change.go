@@ -0,0 +1,159 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"path"
+	"strings"
+)
+
+// ApplyChange applies a Dockerfile instruction to an image config. CMD,
+// ENTRYPOINT, ENV, WORKDIR, USER, LABEL and EXPOSE are understood.
+func ApplyChange(config *v1.ImageConfig, line string) error {
+	line = strings.TrimSpace(line)
+	fields := strings.SplitN(line, " ", 2)
+	instruction := strings.ToUpper(fields[0])
+	args := ""
+	if len(fields) > 1 {
+		args = strings.TrimSpace(fields[1])
+	}
+	if args == "" {
+		return fmt.Errorf("%s needs an argument", instruction)
+	}
+
+	switch instruction {
+	case "CMD":
+		config.Cmd = commandArgs(args)
+	case "ENTRYPOINT":
+		config.Entrypoint = commandArgs(args)
+	case "ENV":
+		pairs, err := keyValues(args)
+		if err != nil {
+			return fmt.Errorf("invalid ENV %s, err: %v", args, err)
+		}
+		for _, pair := range pairs {
+			config.Env = setEnv(config.Env, pair[0], pair[1])
+		}
+	case "LABEL":
+		pairs, err := keyValues(args)
+		if err != nil {
+			return fmt.Errorf("invalid LABEL %s, err: %v", args, err)
+		}
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		for _, pair := range pairs {
+			config.Labels[pair[0]] = pair[1]
+		}
+	case "WORKDIR":
+		// relative directories are relative to the previous one
+		if !path.IsAbs(args) {
+			base := config.WorkingDir
+			if base == "" {
+				base = "/"
+			}
+			args = path.Join(base, args)
+		}
+		config.WorkingDir = path.Clean(args)
+	case "USER":
+		config.User = args
+	case "EXPOSE":
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		for _, port := range strings.Fields(args) {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			config.ExposedPorts[port] = struct{}{}
+		}
+	default:
+		return fmt.Errorf("unsupported change %s, use CMD, ENTRYPOINT, ENV, WORKDIR, USER, LABEL or EXPOSE", instruction)
+	}
+	return nil
+}
+
+// commandArgs takes the exec form ["a", "b"] as it is and runs the shell
+// form through /bin/sh.
+func commandArgs(args string) []string {
+	if strings.HasPrefix(args, "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(args), &list); err == nil {
+			return list
+		}
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// keyValues parses KEY=VALUE pairs, values may be double quoted, or the
+// legacy KEY VALUE form with a single pair.
+func keyValues(args string) ([][2]string, error) {
+	if !strings.Contains(strings.SplitN(args, " ", 2)[0], "=") {
+		fields := strings.SplitN(args, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("missing value")
+		}
+		return [][2]string{{fields[0], strings.TrimSpace(fields[1])}}, nil
+	}
+
+	var pairs [][2]string
+	for args = strings.TrimSpace(args); args != ""; args = strings.TrimSpace(args) {
+		eq := strings.IndexByte(args, '=')
+		if eq <= 0 || strings.ContainsAny(args[:eq], " \t") {
+			return nil, fmt.Errorf("expected KEY=VALUE at %q", args)
+		}
+		key := args[:eq]
+		args = args[eq+1:]
+		var value string
+		if strings.HasPrefix(args, `"`) {
+			end := 1
+			var b strings.Builder
+			for ; end < len(args) && args[end] != '"'; end++ {
+				if args[end] == '\\' && end+1 < len(args) {
+					end++
+				}
+				b.WriteByte(args[end])
+			}
+			if end == len(args) {
+				return nil, fmt.Errorf("unterminated quote in value of %s", key)
+			}
+			value = b.String()
+			args = args[end+1:]
+		} else {
+			end := strings.IndexAny(args, " \t")
+			if end < 0 {
+				end = len(args)
+			}
+			value = args[:end]
+			args = args[end:]
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
+
+// setEnv replaces the variable key in env or appends it.
+func setEnv(env []string, key, value string) []string {
+	for i, kv := range env {
+		if strings.SplitN(kv, "=", 2)[0] == key {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
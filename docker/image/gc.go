@@ -0,0 +1,75 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package image
+
+import (
+	"github.com/go-kinds/docker/common"
+	"strings"
+)
+
+// FindOrphans returns the layer references of containers which are not live
+// and of leases whose process is gone, and the layers nothing references.
+func (s *Store) FindOrphans(liveContainerIDs map[string]bool) ([]common.Orphan, error) {
+	layers, err := s.ListLayers()
+	if err != nil {
+		return nil, err
+	}
+	var orphans []common.Orphan
+	for _, layer := range layers {
+		diffID := layer.DiffID
+		dead := 0
+		for _, ref := range layer.References {
+			if lease, alive := leaseAlive(ref); lease {
+				if alive {
+					continue
+				}
+			} else if id := strings.TrimPrefix(ref, ContainerOwner("")); id == ref || liveContainerIDs[id] {
+				continue
+			}
+			dead++
+			owner := ref
+			orphans = append(orphans, common.Orphan{
+				Kind: "layer reference",
+				Name: diffID + " " + owner,
+				Remove: func() error {
+					return s.ReleaseLayers(owner, []string{diffID})
+				},
+			})
+		}
+		// released along with the last dead reference above
+		if dead > 0 || len(layer.References) > 0 {
+			continue
+		}
+		orphans = append(orphans, common.Orphan{
+			Kind: "layer",
+			Name: diffID,
+			Remove: func() error {
+				return s.removeUnusedLayer(diffID)
+			},
+		})
+	}
+	return orphans, nil
+}
+
+// removeUnusedLayer drops the lease of this process on a layer and removes
+// the layer unless something else references it.
+func (s *Store) removeUnusedLayer(diffID string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.dropReferences(leaseOwner(), []string{diffID}, true)
+}
@@ -0,0 +1,414 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	goruntime "runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Image is a stored image. Its id is the digest of its config.
+type Image struct {
+	ID       string   `json:"id"`
+	RepoTags []string `json:"repo_tags"`
+	// Layers are the diff ids, the base layer first
+	Layers  []string  `json:"layers"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	Config  *v1.Image `json:"config"`
+}
+
+// imageRecord is what is kept in images/<hex>.json, the config and the tags
+// have their own files.
+type imageRecord struct {
+	ID      string    `json:"id"`
+	Layers  []string  `json:"layers"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// NormalizeReference adds the latest tag to references without tag or
+// digest.
+func NormalizeReference(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	if !strings.Contains(name, ":") {
+		return ref + ":latest"
+	}
+	return ref
+}
+
+// SplitReference splits a normalized reference into repository and tag.
+func SplitReference(ref string) (string, string) {
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	i := strings.LastIndex(ref, ":")
+	if i < strings.LastIndex(ref, "/") || i < 0 {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+func (s *Store) readRepositories() (map[string]string, error) {
+	repos := map[string]string{}
+	if err := readJSON(path.Join(s.root, repositoriesFile), &repos); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return repos, nil
+}
+
+func (s *Store) writeRepositories(repos map[string]string) error {
+	return writeJSON(path.Join(s.root, repositoriesFile), repos)
+}
+
+func (s *Store) imagePath(id string) (string, error) {
+	hexPart, err := digestHex(id)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.root, imagesDir, hexPart+".json"), nil
+}
+
+func (s *Store) readImage(id string, repos map[string]string) (*Image, error) {
+	imagePath, err := s.imagePath(id)
+	if err != nil {
+		return nil, err
+	}
+	record := &imageRecord{}
+	if err := readJSON(imagePath, record); err != nil {
+		return nil, err
+	}
+	content, err := s.GetBlob(record.ID)
+	if err != nil {
+		return nil, fmt.Errorf("read config of image %s, err: %v", record.ID, err)
+	}
+	config := &v1.Image{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, err
+	}
+	img := &Image{
+		ID:      record.ID,
+		Layers:  record.Layers,
+		Size:    record.Size,
+		Created: record.Created,
+		Config:  config,
+	}
+	for ref, imageID := range repos {
+		if imageID == img.ID {
+			img.RepoTags = append(img.RepoTags, ref)
+		}
+	}
+	sort.Strings(img.RepoTags)
+	return img, nil
+}
+
+func (s *Store) listIDs() ([]string, error) {
+	entries, err := ioutil.ReadDir(path.Join(s.root, imagesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".json") {
+			ids = append(ids, "sha256:"+strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	return ids, nil
+}
+
+// List returns the images, the most recent first.
+func (s *Store) List() ([]*Image, error) {
+	repos, err := s.readRepositories()
+	if err != nil {
+		return nil, err
+	}
+	ids, err := s.listIDs()
+	if err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, id := range ids {
+		img, err := s.readImage(id, repos)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Created.After(images[j].Created)
+	})
+	return images, nil
+}
+
+// Get looks an image up by name:tag, by id or by an unambiguous id prefix.
+func (s *Store) Get(ref string) (*Image, error) {
+	repos, err := s.readRepositories()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.resolve(ref, repos)
+	if err != nil {
+		return nil, err
+	}
+	return s.readImage(id, repos)
+}
+
+func (s *Store) resolve(ref string, repos map[string]string) (string, error) {
+	if id, ok := repos[NormalizeReference(ref)]; ok {
+		return id, nil
+	}
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	if len(prefix) >= 4 && strings.Trim(prefix, "0123456789abcdef") == "" {
+		ids, err := s.listIDs()
+		if err != nil {
+			return "", err
+		}
+		var found []string
+		for _, id := range ids {
+			if strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), prefix) {
+				found = append(found, id)
+			}
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
+		if len(found) > 1 {
+			return "", fmt.Errorf("image id prefix %s is ambiguous", ref)
+		}
+	}
+	return "", fmt.Errorf("no such image: %s", ref)
+}
+
+// Create stores an image made of stored layers, the base layer first, and
+// tags it with refs. The rootfs of the config is filled in from the layers.
+func (s *Store) Create(config *v1.Image, diffIDs []string, refs ...string) (*Image, error) {
+	config.RootFS = v1.RootFS{Type: "layers"}
+	for _, diffID := range diffIDs {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, digest.Digest(diffID))
+	}
+	if config.OS == "" {
+		config.OS = "linux"
+	}
+	if config.Architecture == "" {
+		config.Architecture = goruntime.GOARCH
+	}
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return s.CreateFromConfig(content, refs...)
+}
+
+// CreateFromConfig stores an image given its raw config, which keeps the id
+// an image had elsewhere. The layers of the config have to be stored.
+func (s *Store) CreateFromConfig(content []byte, refs ...string) (*Image, error) {
+	config := &v1.Image{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("parse image config, err: %v", err)
+	}
+	var diffIDs []string
+	for _, diffID := range config.RootFS.DiffIDs {
+		diffIDs = append(diffIDs, diffID.String())
+	}
+	id, err := s.PutBlob(content)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	record := &imageRecord{ID: id, Layers: diffIDs, Created: time.Now()}
+	if config.Created != nil {
+		record.Created = *config.Created
+	}
+	for _, diffID := range diffIDs {
+		layer, err := s.GetLayer(diffID)
+		if err != nil {
+			return nil, err
+		}
+		record.Size += layer.Size
+	}
+	if err := s.acquireLayers(imageOwner(id), diffIDs); err != nil {
+		return nil, err
+	}
+	imagePath, _ := s.imagePath(id)
+	if err := writeJSON(imagePath, record); err != nil {
+		return nil, err
+	}
+	// the image holds the layers from now on
+	if err := s.dropReferences(leaseOwner(), diffIDs, false); err != nil {
+		return nil, err
+	}
+
+	repos, err := s.readRepositories()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		repos[NormalizeReference(ref)] = id
+	}
+	if err := s.writeRepositories(repos); err != nil {
+		return nil, err
+	}
+	return s.readImage(id, repos)
+}
+
+// Tag points target at the image ref refers to.
+func (s *Store) Tag(ref, target string) error {
+	if strings.Contains(target, "@") {
+		return fmt.Errorf("invalid tag %s, digests are not tags", target)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	repos, err := s.readRepositories()
+	if err != nil {
+		return err
+	}
+	id, err := s.resolve(ref, repos)
+	if err != nil {
+		return err
+	}
+	repos[NormalizeReference(target)] = id
+	return s.writeRepositories(repos)
+}
+
+// Remove untags ref if the image has other tags, otherwise it deletes the
+// image together with its layers which nothing else uses. An image with
+// several tags is deleted by id only with force, which drops all its tags.
+// It returns what was untagged and deleted.
+func (s *Store) Remove(ref string, force bool) ([]string, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	repos, err := s.readRepositories()
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.resolve(ref, repos)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.readImage(id, repos)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	normalized := NormalizeReference(ref)
+	_, isTag := repos[normalized]
+	if len(img.RepoTags) > 1 && !force {
+		if !isTag {
+			return nil, fmt.Errorf("conflict: unable to delete %s (must be forced), image is referenced by %d tags", ref, len(img.RepoTags))
+		}
+		delete(repos, normalized)
+		return []string{"Untagged: " + normalized}, s.writeRepositories(repos)
+	}
+	for _, tag := range img.RepoTags {
+		delete(repos, tag)
+		result = append(result, "Untagged: "+tag)
+	}
+	if err := s.writeRepositories(repos); err != nil {
+		return nil, err
+	}
+
+	imagePath, _ := s.imagePath(id)
+	if err := os.Remove(imagePath); err != nil {
+		return nil, err
+	}
+	if blobPath, err := s.blobPath(id); err == nil {
+		_ = os.Remove(blobPath)
+	}
+	if err := s.releaseLayers(imageOwner(id), img.Layers); err != nil {
+		return nil, err
+	}
+	return append(result, "Deleted: "+id), nil
+}
+
+// ImportRootfs stores a flat root file system tar as a single layer image.
+func (s *Store) ImportRootfs(tarPath string, refs ...string) (*Image, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	created := time.Now().UTC()
+	config := &v1.Image{
+		Created: &created,
+		History: []v1.History{{Created: &created, CreatedBy: "import " + path.Base(tarPath)}},
+	}
+	return s.Import(f, config, refs...)
+}
+
+// Import stores a flat root file system tar, compressed or not, as a single
+// layer image with config.
+func (s *Store) Import(r io.Reader, config *v1.Image, refs ...string) (*Image, error) {
+	tarStream, err := archive.Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer tarStream.Close()
+	layer, err := s.PutLayer(tarStream)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.Create(config, []string{layer.DiffID}, refs...)
+	if err != nil {
+		_ = s.removeUnusedLayer(layer.DiffID)
+		return nil, err
+	}
+	return img, nil
+}
+
+// Extend stores a layer tar on top of the layers of base as a new image with
+// config. The layer is removed again if the image cannot be created.
+func (s *Store) Extend(base *Image, config *v1.Image, r io.Reader, refs ...string) (*Image, error) {
+	layer, err := s.PutLayer(r)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := append(append([]string(nil), base.Layers...), layer.DiffID)
+	img, err := s.Create(config, diffIDs, refs...)
+	if err != nil {
+		_ = s.removeUnusedLayer(layer.DiffID)
+		return nil, err
+	}
+	return img, nil
+}
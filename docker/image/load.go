@@ -0,0 +1,416 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+)
+
+// dockerManifest is an entry of the manifest.json written by docker save.
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+const (
+	dockerManifestFile = "manifest.json"
+	ociLayoutFile      = "oci-layout"
+	ociIndexFile       = "index.json"
+
+	// annotations naming the image of a manifest in an OCI layout
+	annotationRefName       = "org.opencontainers.image.ref.name"
+	annotationContainerdRef = "io.containerd.image.name"
+)
+
+// LoadResult is an image loaded from an archive, Refs are the tags it got.
+type LoadResult struct {
+	Image *Image
+	Refs  []string
+}
+
+// Load imports the images of a docker save archive or an OCI image layout
+// tar. Every blob is verified against its digest.
+func (s *Store) Load(r io.Reader) ([]LoadResult, error) {
+	tmp := path.Join(s.root, tmpDir)
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(tmp, "load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := unpackArchive(r, dir); err != nil {
+		return nil, fmt.Errorf("read archive, err: %v", err)
+	}
+
+	// docker save of recent versions writes both, the OCI layout is richer
+	if _, err := os.Stat(path.Join(dir, ociLayoutFile)); err == nil {
+		return s.loadOCILayout(dir)
+	}
+	if _, err := os.Stat(path.Join(dir, dockerManifestFile)); err == nil {
+		return s.loadDockerArchive(dir)
+	}
+	return nil, fmt.Errorf("archive is neither a docker save archive nor an OCI image layout")
+}
+
+func (s *Store) loadDockerArchive(dir string) ([]LoadResult, error) {
+	var manifests []dockerManifest
+	if err := readArchiveJSON(dir, dockerManifestFile, &manifests); err != nil {
+		return nil, err
+	}
+	var results []LoadResult
+	for _, m := range manifests {
+		configContent, err := readArchiveFile(dir, m.Config)
+		if err != nil {
+			return nil, err
+		}
+		// the config is named after its digest
+		if name := strings.TrimSuffix(path.Base(m.Config), ".json"); len(name) == sha256.Size*2 {
+			if err := verify(configContent, "sha256:"+name); err != nil {
+				return nil, err
+			}
+		}
+		config := &v1.Image{}
+		if err := json.Unmarshal(configContent, config); err != nil {
+			return nil, fmt.Errorf("parse image config %s, err: %v", m.Config, err)
+		}
+		if len(config.RootFS.DiffIDs) != len(m.Layers) {
+			return nil, fmt.Errorf("image config %s lists %d layers, the manifest %d", m.Config, len(config.RootFS.DiffIDs), len(m.Layers))
+		}
+		var layers []LayerBlob
+		for i, layerFile := range m.Layers {
+			layers = append(layers, LayerBlob{
+				Name:   layerFile,
+				Open:   archiveOpener(dir, layerFile),
+				DiffID: config.RootFS.DiffIDs[i].String(),
+			})
+		}
+		img, err := s.PutImage(configContent, layers, m.RepoTags)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, LoadResult{Image: img, Refs: m.RepoTags})
+	}
+	return results, nil
+}
+
+func (s *Store) loadOCILayout(dir string) ([]LoadResult, error) {
+	index := &v1.Index{}
+	if err := readArchiveJSON(dir, ociIndexFile, index); err != nil {
+		return nil, err
+	}
+	var results []LoadResult
+	for _, desc := range index.Manifests {
+		ref := desc.Annotations[annotationContainerdRef]
+		if ref == "" {
+			// a bare tag has no repository to go with
+			if name := desc.Annotations[annotationRefName]; strings.ContainsAny(name, "/:") {
+				ref = name
+			}
+		}
+		manifest, err := s.resolveManifest(dir, desc)
+		if err != nil {
+			return nil, err
+		}
+		img, err := s.loadOCIManifest(dir, manifest, ref)
+		if err != nil {
+			return nil, err
+		}
+		result := LoadResult{Image: img}
+		if ref != "" {
+			result.Refs = []string{ref}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// resolveManifest follows image indexes down to the manifest of the local
+// platform.
+func (s *Store) resolveManifest(dir string, desc v1.Descriptor) (*v1.Manifest, error) {
+	for {
+		content, err := readBlob(dir, desc)
+		if err != nil {
+			return nil, err
+		}
+		switch desc.MediaType {
+		case v1.MediaTypeImageIndex, mediaTypeDockerManifestList:
+			index := &v1.Index{}
+			if err := json.Unmarshal(content, index); err != nil {
+				return nil, err
+			}
+			next, err := SelectPlatform(index.Manifests, "linux", goruntime.GOARCH)
+			if err != nil {
+				return nil, err
+			}
+			desc = next
+		default:
+			manifest := &v1.Manifest{}
+			if err := json.Unmarshal(content, manifest); err != nil {
+				return nil, err
+			}
+			return manifest, nil
+		}
+	}
+}
+
+func (s *Store) loadOCIManifest(dir string, manifest *v1.Manifest, ref string) (*Image, error) {
+	configContent, err := readBlob(dir, manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	config := &v1.Image{}
+	if err := json.Unmarshal(configContent, config); err != nil {
+		return nil, fmt.Errorf("parse image config, err: %v", err)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("image config lists %d layers, the manifest %d", len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	var layers []LayerBlob
+	for i, layer := range manifest.Layers {
+		name := blobFile(layer.Digest.String())
+		layers = append(layers, LayerBlob{
+			Name:   name,
+			Open:   archiveOpener(dir, name),
+			Digest: layer.Digest.String(),
+			DiffID: config.RootFS.DiffIDs[i].String(),
+		})
+	}
+	var refs []string
+	if ref != "" {
+		refs = append(refs, ref)
+	}
+	return s.PutImage(configContent, layers, refs)
+}
+
+func archiveOpener(dir, name string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return openArchiveFile(dir, name)
+	}
+}
+
+// LayerBlob is a possibly compressed layer of an image being stored. Digest
+// is the one of the blob, if known, DiffID the one of its content.
+type LayerBlob struct {
+	Name   string
+	Open   func() (io.ReadCloser, error)
+	Digest string
+	DiffID string
+}
+
+// PutImage stores the missing layers and the image given its raw config.
+// Layers stored for an image which fails are discarded again.
+func (s *Store) PutImage(configContent []byte, layers []LayerBlob, refs []string) (img *Image, err error) {
+	var stored []string
+	defer func() {
+		if err != nil {
+			for _, diffID := range stored {
+				_ = s.removeUnusedLayer(diffID)
+			}
+		}
+	}()
+	for _, layer := range layers {
+		if _, err := s.GetLayer(layer.DiffID); err == nil {
+			continue
+		}
+		if err = s.putLayerBlob(layer); err != nil {
+			return nil, err
+		}
+		stored = append(stored, layer.DiffID)
+	}
+	return s.CreateFromConfig(configContent, refs...)
+}
+
+// putLayerBlob stores a layer, checking the digest of the blob, if known,
+// and the diff id of its content.
+func (s *Store) putLayerBlob(blobLayer LayerBlob) error {
+	f, err := blobLayer.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	blobHash := sha256.New()
+	blob := io.TeeReader(f, blobHash)
+	content, err := archive.Decompress(blob)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	layer, err := s.PutLayer(content)
+	if err != nil {
+		return err
+	}
+	// drain what the decompressor left, so the blob digest covers everything
+	if _, err := io.Copy(ioutil.Discard, blob); err != nil {
+		return err
+	}
+	if blobLayer.Digest != "" && "sha256:"+hex.EncodeToString(blobHash.Sum(nil)) != blobLayer.Digest {
+		_ = s.removeUnusedLayer(layer.DiffID)
+		return fmt.Errorf("layer %s does not match its digest", blobLayer.Name)
+	}
+	if layer.DiffID != blobLayer.DiffID {
+		_ = s.removeUnusedLayer(layer.DiffID)
+		return fmt.Errorf("layer %s has diff id %s, the image config expects %s", blobLayer.Name, layer.DiffID, blobLayer.DiffID)
+	}
+	return nil
+}
+
+const mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+// SelectPlatform picks the manifest of an image index for a platform.
+func SelectPlatform(manifests []v1.Descriptor, goos, arch string) (v1.Descriptor, error) {
+	for _, desc := range manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		if desc.Platform.OS == goos && desc.Platform.Architecture == arch {
+			return desc, nil
+		}
+	}
+	// an index of a single manifest without platform is common for local
+	// builds
+	if len(manifests) == 1 && manifests[0].Platform == nil {
+		return manifests[0], nil
+	}
+	return v1.Descriptor{}, fmt.Errorf("no image for platform %s/%s", goos, arch)
+}
+
+func blobFile(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+func readBlob(dir string, desc v1.Descriptor) ([]byte, error) {
+	content, err := readArchiveFile(dir, blobFile(desc.Digest.String()))
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(content, desc.Digest.String()); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func verify(content []byte, digest string) error {
+	sum := sha256.Sum256(content)
+	if "sha256:"+hex.EncodeToString(sum[:]) != digest {
+		return fmt.Errorf("content does not match digest %s", digest)
+	}
+	return nil
+}
+
+// openArchiveFile opens a file of the unpacked archive, symlinks included,
+// as long as it stays inside the archive.
+func openArchiveFile(dir, name string) (*os.File, error) {
+	resolved, err := filepath.EvalSymlinks(filepath.Join(dir, filepath.Clean("/"+name)))
+	if err != nil {
+		return nil, fmt.Errorf("archive has no %s", name)
+	}
+	if !strings.HasPrefix(resolved, filepath.Clean(dir)+"/") {
+		return nil, fmt.Errorf("archive entry %s points outside the archive", name)
+	}
+	return os.Open(resolved)
+}
+
+func readArchiveFile(dir, name string) ([]byte, error) {
+	f, err := openArchiveFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+func readArchiveJSON(dir, name string, v interface{}) error {
+	content, err := readArchiveFile(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("parse %s, err: %v", name, err)
+	}
+	return nil
+}
+
+// unpackArchive writes the directories, regular files and symlinks of an
+// image archive into dir. Entries escaping dir, directly or below one of its
+// symlinks, are rejected.
+func unpackArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		if filepath.Clean(hdr.Name) != strings.TrimPrefix(name, "/") {
+			return fmt.Errorf("archive entry %s escapes the archive", hdr.Name)
+		}
+		parent, err := archive.ResolveInRoot(dir, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		if parent != filepath.Join(dir, filepath.Dir(name)) {
+			return fmt.Errorf("archive entry %s is below a symlink", hdr.Name)
+		}
+		if err := os.MkdirAll(parent, 0700); err != nil {
+			return err
+		}
+		target := filepath.Join(parent, filepath.Base(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.Mkdir(target, 0700); err != nil {
+				if fi, lerr := os.Lstat(target); lerr != nil || !fi.IsDir() {
+					return err
+				}
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|unix.O_NOFOLLOW, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
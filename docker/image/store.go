@@ -0,0 +1,574 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package image is the local content addressable image store. Below the
+// image directory of the root it keeps
+//
+//	blobs/sha256/<hex>        image configs and other blobs
+//	layers/<hex>/diff         every layer unpacked once, keyed by its diff id
+//	layers/<hex>/layer.json   size and the images and containers using it
+//	layers/<hex>/tar-split.json.gz
+//	                          tar headers to reassemble the exact layer tar
+//	images/<hex>.json         images keyed by the digest of their config
+//	repositories.json         name:tag references of the images
+//	downloads/<hex>           blobs being pulled, kept to resume failed pulls
+//	build-cache/<hex>         diff ids of the layers build steps made
+package image
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/storage"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/vbatts/tar-split/tar/asm"
+	tarstorage "github.com/vbatts/tar-split/tar/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	blobsDir         = "blobs/sha256"
+	layersDir        = "layers"
+	imagesDir        = "images"
+	tmpDir           = "tmp"
+	downloadsDir     = "downloads"
+	buildCacheDir    = "build-cache"
+	repositoriesFile = "repositories.json"
+	lockFile         = "lock"
+	layerFile        = "layer.json"
+	tarSplitFile     = "tar-split.json.gz"
+	diffDir          = "diff"
+)
+
+type Store struct {
+	root   string
+	driver string
+}
+
+func NewStore(cfg *common.Config) *Store {
+	return &Store{root: cfg.ImagePath(), driver: cfg.StorageDriver}
+}
+
+// Layer is an unpacked layer, References are the images and containers
+// using it. A layer goes away with its last reference.
+type Layer struct {
+	DiffID     string   `json:"diff_id"`
+	Size       int64    `json:"size"`
+	References []string `json:"references"`
+	// Blob is the compressed form the layer was last pulled or pushed as
+	Blob *Blob `json:"blob,omitempty"`
+}
+
+// Blob is a compressed layer as registries keep it. Repositories are the
+// registry/repository names known to have it.
+type Blob struct {
+	Digest       string   `json:"digest"`
+	Size         int64    `json:"size"`
+	MediaType    string   `json:"media_type"`
+	Repositories []string `json:"repositories,omitempty"`
+}
+
+// ContainerOwner is the layer reference of a container.
+func ContainerOwner(containerID string) string {
+	return "container:" + containerID
+}
+
+func imageOwner(id string) string {
+	return "image:" + id
+}
+
+const leasePrefix = "lease:"
+
+// leaseOwner references the layers this process stores until the images
+// using them are written, so garbage collection running meanwhile leaves
+// them alone. A lease ends with its process at the latest.
+func leaseOwner() string {
+	return leasePrefix + strconv.Itoa(os.Getpid())
+}
+
+// leaseAlive tells whether ref is a lease and whether its process still
+// runs.
+func leaseAlive(ref string) (lease, alive bool) {
+	if !strings.HasPrefix(ref, leasePrefix) {
+		return false, false
+	}
+	pid, err := strconv.Atoi(strings.TrimPrefix(ref, leasePrefix))
+	if err != nil || pid <= 0 {
+		return true, false
+	}
+	err = syscall.Kill(pid, 0)
+	return true, err == nil || err == syscall.EPERM
+}
+
+// lock serializes changes of the store between processes.
+func (s *Store) lock() (func(), error) {
+	if err := os.MkdirAll(s.root, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(s.root, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// digestHex returns the hex part of a sha256 digest, which is also accepted
+// without its algorithm prefix.
+func digestHex(digest string) (string, error) {
+	hexPart := strings.TrimPrefix(digest, "sha256:")
+	if len(hexPart) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid digest: %s", digest)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return "", fmt.Errorf("invalid digest: %s", digest)
+	}
+	return hexPart, nil
+}
+
+// writeFileAtomic replaces filePath, readers never see partial content.
+func writeFileAtomic(filePath string, content []byte) error {
+	if err := os.MkdirAll(path.Dir(filePath), 0700); err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+func writeJSON(filePath string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, content)
+}
+
+func readJSON(filePath string, v interface{}) error {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+func (s *Store) blobPath(digest string) (string, error) {
+	hexPart, err := digestHex(digest)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.root, blobsDir, hexPart), nil
+}
+
+// PutBlob stores content under its sha256 digest and returns the digest.
+func (s *Store) PutBlob(content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	blobPath, _ := s.blobPath(digest)
+	if _, err := os.Stat(blobPath); err == nil {
+		return digest, nil
+	}
+	return digest, writeFileAtomic(blobPath, content)
+}
+
+func (s *Store) GetBlob(digest string) ([]byte, error) {
+	blobPath, err := s.blobPath(digest)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(blobPath)
+}
+
+// DownloadPath is where a blob is downloaded to before it is stored. A
+// partial download is kept there, so a failed pull resumes where it stopped.
+func (s *Store) DownloadPath(digest string) (string, error) {
+	hexPart, err := digestHex(digest)
+	if err != nil {
+		return "", err
+	}
+	dir := path.Join(s.root, downloadsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return path.Join(dir, hexPart), nil
+}
+
+func (s *Store) layerPath(diffID string) (string, error) {
+	hexPart, err := digestHex(diffID)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(s.root, layersDir, hexPart), nil
+}
+
+// LayerDir is the directory holding the unpacked content of a layer.
+func (s *Store) LayerDir(diffID string) string {
+	layerPath, err := s.layerPath(diffID)
+	if err != nil {
+		return ""
+	}
+	return path.Join(layerPath, diffDir)
+}
+
+func (s *Store) GetLayer(diffID string) (*Layer, error) {
+	layerPath, err := s.layerPath(diffID)
+	if err != nil {
+		return nil, err
+	}
+	layer := &Layer{}
+	if err := readJSON(path.Join(layerPath, layerFile), layer); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such layer: %s", diffID)
+		}
+		return nil, err
+	}
+	return layer, nil
+}
+
+func (s *Store) ListLayers() ([]*Layer, error) {
+	entries, err := ioutil.ReadDir(path.Join(s.root, layersDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var layers []*Layer
+	for _, entry := range entries {
+		layer, err := s.GetLayer("sha256:" + entry.Name())
+		if err != nil {
+			// a layer being unpacked has no record yet
+			continue
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// PutLayer unpacks an uncompressed layer tar, unless a layer with the same
+// diff id is stored already. Either way the layer is leased to this process
+// until an image using it is created, so garbage collection keeps it.
+func (s *Store) PutLayer(r io.Reader) (*Layer, error) {
+	driver, err := storage.Get(s.driver)
+	if err != nil {
+		return nil, err
+	}
+	tmp := path.Join(s.root, tmpDir)
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return nil, err
+	}
+	unpackDir, err := ioutil.TempDir(tmp, "unpack-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(unpackDir)
+	if err := os.Mkdir(path.Join(unpackDir, diffDir), 0755); err != nil {
+		return nil, err
+	}
+
+	// the diff id is only known once the layer is unpacked
+	hash := sha256.New()
+	counter := &countWriter{}
+	// tar-split keeps what unpacking loses, so the layer tar can be pushed
+	// later on with the same diff id
+	tarSplit, err := os.Create(path.Join(unpackDir, tarSplitFile))
+	if err != nil {
+		return nil, err
+	}
+	defer tarSplit.Close()
+	tarSplitGzip := gzip.NewWriter(tarSplit)
+	content, err := asm.NewInputTarStream(io.TeeReader(r, io.MultiWriter(hash, counter)),
+		tarstorage.NewJSONPacker(tarSplitGzip), tarstorage.NewDiscardFilePutter())
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Apply(content, path.Join(unpackDir, diffDir), driver); err != nil {
+		// tar-split is blocked until its output is read
+		_, _ = io.Copy(ioutil.Discard, content)
+		return nil, fmt.Errorf("unpack layer, err: %v", err)
+	}
+	// the padding after the end of the archive belongs to the diff id as well
+	if _, err := io.Copy(ioutil.Discard, content); err != nil {
+		return nil, fmt.Errorf("read layer, err: %v", err)
+	}
+	if err := tarSplitGzip.Close(); err != nil {
+		return nil, err
+	}
+	diffID := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if layer, err := s.leaseLayer(diffID); err == nil {
+		return layer, nil
+	}
+	layer := &Layer{DiffID: diffID, Size: counter.n, References: []string{leaseOwner()}}
+	if err := writeJSON(path.Join(unpackDir, layerFile), layer); err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	// someone else may have unpacked the same layer meanwhile
+	if _, err := s.GetLayer(diffID); err == nil {
+		if err := s.acquireLayers(leaseOwner(), []string{diffID}); err != nil {
+			return nil, err
+		}
+		return s.GetLayer(diffID)
+	}
+	layerPath, _ := s.layerPath(diffID)
+	if err := os.MkdirAll(path.Dir(layerPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(unpackDir, layerPath); err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+// LayerTar reassembles the tar a layer was stored from.
+func (s *Store) LayerTar(diffID string) (io.ReadCloser, error) {
+	layerPath, err := s.layerPath(diffID)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path.Join(layerPath, tarSplitFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("layer %s was stored without its tar headers, pull or load it again", diffID)
+		}
+		return nil, err
+	}
+	metadata, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tarStream := asm.NewOutputTarStream(layerFileGetter(path.Join(layerPath, diffDir)), tarstorage.NewJSONUnpacker(metadata))
+	return &closers{Reader: tarStream, closers: []io.Closer{tarStream, metadata, f}}, nil
+}
+
+// CompressLayer writes the gzip compressed tar of a layer to a temporary
+// file and records it as the blob of the layer. The caller removes the file.
+func (s *Store) CompressLayer(diffID string) (string, *Blob, error) {
+	tarStream, err := s.LayerTar(diffID)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tarStream.Close()
+	tmp := path.Join(s.root, tmpDir)
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return "", nil, err
+	}
+	f, err := ioutil.TempFile(tmp, "blob-")
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	counter := &countWriter{}
+	compressor := gzip.NewWriter(io.MultiWriter(f, hash, counter))
+	_, err = io.Copy(compressor, tarStream)
+	if err == nil {
+		err = compressor.Close()
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", nil, fmt.Errorf("compress layer %s, err: %v", diffID, err)
+	}
+	blob := &Blob{
+		Digest:    "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Size:      counter.n,
+		MediaType: v1.MediaTypeImageLayerGzip,
+	}
+	if err := s.RecordLayerBlob(diffID, *blob); err != nil {
+		_ = os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), blob, nil
+}
+
+// layerFileGetter reads the files of an unpacked layer, resolving their
+// names the way they were unpacked.
+type layerFileGetter string
+
+func (root layerFileGetter) Get(name string) (io.ReadCloser, error) {
+	filePath, err := archive.ResolveInRoot(string(root), name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filePath)
+}
+
+type closers struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (c *closers) Close() error {
+	var firstErr error
+	for _, closer := range c.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// RecordLayerBlob remembers the compressed form of a layer and a
+// repository having it, pushes skip or mount blobs they know.
+func (s *Store) RecordLayerBlob(diffID string, blob Blob) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	layer, err := s.GetLayer(diffID)
+	if err != nil {
+		return err
+	}
+	if layer.Blob == nil || layer.Blob.Digest != blob.Digest {
+		layer.Blob = &Blob{Digest: blob.Digest, Size: blob.Size, MediaType: blob.MediaType}
+	}
+	for _, repo := range blob.Repositories {
+		if !contains(layer.Blob.Repositories, repo) {
+			layer.Blob.Repositories = append(layer.Blob.Repositories, repo)
+		}
+	}
+	layerPath, _ := s.layerPath(diffID)
+	return writeJSON(path.Join(layerPath, layerFile), layer)
+}
+
+// leaseLayer takes the lease of this process on a stored layer.
+func (s *Store) leaseLayer(diffID string) (*Layer, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := s.acquireLayers(leaseOwner(), []string{diffID}); err != nil {
+		return nil, err
+	}
+	return s.GetLayer(diffID)
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// AcquireLayers adds owner to the references of the layers.
+func (s *Store) AcquireLayers(owner string, diffIDs []string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.acquireLayers(owner, diffIDs)
+}
+
+func (s *Store) acquireLayers(owner string, diffIDs []string) error {
+	for _, diffID := range diffIDs {
+		layer, err := s.GetLayer(diffID)
+		if err != nil {
+			return err
+		}
+		if !contains(layer.References, owner) {
+			layer.References = append(layer.References, owner)
+		}
+		layerPath, _ := s.layerPath(diffID)
+		if err := writeJSON(path.Join(layerPath, layerFile), layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseLayers drops owner from the references of the layers, layers left
+// without references are removed.
+func (s *Store) ReleaseLayers(owner string, diffIDs []string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.releaseLayers(owner, diffIDs)
+}
+
+func (s *Store) releaseLayers(owner string, diffIDs []string) error {
+	return s.dropReferences(owner, diffIDs, true)
+}
+
+// dropReferences drops owner from the references of the layers, with
+// removeUnused layers left without references are removed.
+func (s *Store) dropReferences(owner string, diffIDs []string, removeUnused bool) error {
+	for _, diffID := range diffIDs {
+		layer, err := s.GetLayer(diffID)
+		if err != nil {
+			continue
+		}
+		var refs []string
+		for _, ref := range layer.References {
+			if ref != owner {
+				refs = append(refs, ref)
+			}
+		}
+		layerPath, _ := s.layerPath(diffID)
+		if len(refs) == 0 && removeUnused {
+			if err := os.RemoveAll(layerPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if refs == nil {
+			refs = []string{}
+		}
+		layer.References = refs
+		if err := writeJSON(path.Join(layerPath, layerFile), layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -16,6 +16,10 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/runtime"
+	"github.com/go-kinds/docker/storage"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
@@ -23,19 +27,93 @@ import (
 
 const usage = `go-docker`
 
+// dockerRuntime is set up from the config file and the global flags before
+// any command runs
+var dockerRuntime *runtime.Runtime
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "go-docker"
 	app.Usage = usage
 
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: fmt.Sprintf("config file, defaults to $%s or %s", common.ConfigFileEnv, common.DefaultConfigFile),
+		},
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "root directory of images, write layers and mount points",
+		},
+		cli.StringFlag{
+			Name:  "state-dir",
+			Usage: "directory of container records and network state",
+		},
+		cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "storage driver of images and containers, aufs or overlay, detected by default",
+		},
+	}
+
 	app.Commands = []cli.Command{
 		runCommand,
 		initCommand,
+		shimCommand,
+		attachCommand,
+		psCommand,
+		execCommand,
+		killCommand,
+		rmCommand,
+		createCommand,
+		startCommand,
+		stateCommand,
+		deleteCommand,
+		commitCommand,
+		exportCommand,
+		diffCommand,
+		importCommand,
+		buildCommand,
+		imagesCommand,
+		rmiCommand,
+		tagCommand,
+		loadCommand,
+		pullCommand,
+		pushCommand,
+		imageCommand,
+		volumeCommand,
+		inspectCommand,
+		systemCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
-		logrus.SetOutput(os.Stdout)
+		// stdout carries the data of commands like export, state and inspect
+		logrus.SetOutput(os.Stderr)
+
+		conf, err := common.LoadConfig(context.GlobalString("config"))
+		if err != nil {
+			return fmt.Errorf("load config, err: %v", err)
+		}
+		if root := context.GlobalString("root"); root != "" {
+			conf.Root = root
+		}
+		if stateDir := context.GlobalString("state-dir"); stateDir != "" {
+			conf.StateDir = stateDir
+		}
+		if driver := context.GlobalString("storage-driver"); driver != "" {
+			conf.StorageDriver = driver
+		}
+		if _, err := storage.Get(conf.StorageDriver); err != nil {
+			return err
+		}
+		dockerRuntime = runtime.New(conf)
+
+		// the init and shim processes belong to a container being set up
+		switch context.Args().First() {
+		case initCommand.Name, shimCommand.Name:
+		default:
+			dockerRuntime.Reconcile()
+		}
 		return nil
 	}
 	if err := app.Run(os.Args); err != nil {
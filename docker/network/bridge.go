@@ -83,7 +83,15 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 }
 
 func (d *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
-	return nil
+	// deleting one end of the veth pair removes its peer as well
+	veth, err := netlink.LinkByName(endpoint.ID[:5])
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	return netlink.LinkDel(veth)
 }
 
 func (d *BridgeNetworkDriver) initBridge(n *Network) error {
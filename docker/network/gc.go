@@ -0,0 +1,168 @@
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package network
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/container"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// FindOrphans cross-references the given live containers with the veth
+// devices, ip allocations and port mapping rules of the known networks, and
+// returns the ones no live container owns. Init has to be called first.
+func FindOrphans(cfg *common.Config, live []*container.ContainerInfo) ([]common.Orphan, error) {
+	if len(networks) == 0 {
+		return nil, nil
+	}
+	ownedDevices := map[string]bool{}
+	ownedIPs := map[string]bool{}
+	for _, info := range live {
+		if len(info.Id) >= 5 {
+			ownedDevices[info.Id[:5]] = true
+		}
+		if info.IPAddress != "" {
+			ownedIPs[info.IPAddress] = true
+		}
+	}
+
+	var orphans []common.Orphan
+	rules, err := orphanPortMappings(ownedIPs)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, rules...)
+
+	devices, err := orphanEndpointDevices(ownedDevices)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, devices...)
+
+	ipam := newIPAM(cfg)
+	for _, nw := range networks {
+		if nw.IpRange == nil {
+			continue
+		}
+		ips, err := ipam.Allocated(nw.IpRange)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			// the gateway belongs to the network itself
+			if ip.Equal(nw.IpRange.IP) || ownedIPs[ip.String()] {
+				continue
+			}
+			subnet, ip := nw.IpRange, ip
+			orphans = append(orphans, common.Orphan{
+				Kind: "ip address",
+				Name: fmt.Sprintf("%s (%s)", ip, nw.Name),
+				Remove: func() error {
+					return ipam.Release(subnet, &ip)
+				},
+			})
+		}
+	}
+	return orphans, nil
+}
+
+func orphanEndpointDevices(owned map[string]bool) ([]common.Orphan, error) {
+	bridges := map[int]string{}
+	for _, nw := range networks {
+		if br, err := netlink.LinkByName(nw.Name); err == nil {
+			bridges[br.Attrs().Index] = nw.Name
+		}
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	var orphans []common.Orphan
+	for _, link := range links {
+		attrs := link.Attrs()
+		if link.Type() != "veth" || owned[attrs.Name] {
+			continue
+		}
+		if _, ok := bridges[attrs.MasterIndex]; !ok {
+			continue
+		}
+		link := link
+		orphans = append(orphans, common.Orphan{
+			Kind: "veth device",
+			Name: attrs.Name,
+			Remove: func() error {
+				return netlink.LinkDel(link)
+			},
+		})
+	}
+	return orphans, nil
+}
+
+// orphanPortMappings finds the DNAT rules pointing to an address of one of
+// our networks which no container holds any more.
+func orphanPortMappings(owned map[string]bool) ([]common.Orphan, error) {
+	output, err := exec.Command("iptables", "-t", "nat", "-S", "PREROUTING").Output()
+	if err != nil {
+		return nil, fmt.Errorf("list iptables rules, err: %v", err)
+	}
+	var orphans []common.Orphan
+	for _, rule := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(rule)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		var destination string
+		for i := range fields {
+			if fields[i] == "--to-destination" && i+1 < len(fields) {
+				destination = fields[i+1]
+			}
+		}
+		host, _, err := net.SplitHostPort(destination)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || owned[ip.String()] || !inNetworks(ip) {
+			continue
+		}
+		fields[0] = "-D"
+		args := append([]string{"-t", "nat"}, fields...)
+		orphans = append(orphans, common.Orphan{
+			Kind: "port mapping",
+			Name: strings.TrimSpace(rule),
+			Remove: func() error {
+				output, err := exec.Command("iptables", args...).CombinedOutput()
+				if err != nil {
+					logrus.Errorf("iptables output: %s", output)
+				}
+				return err
+			},
+		})
+	}
+	return orphans, nil
+}
+
+func inNetworks(ip net.IP) bool {
+	for _, nw := range networks {
+		if nw.IpRange != nil && nw.IpRange.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
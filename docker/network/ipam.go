@@ -29,12 +29,17 @@ type IPAM struct {
 	Subnets             *map[string]string
 }
 
-var ipAllocator = &IPAM{
-	SubnetAllocatorPath: common.DefaultAllocatorPath,
+func newIPAM(cfg *common.Config) *IPAM {
+	return &IPAM{
+		SubnetAllocatorPath: cfg.AllocatorPath(),
+	}
 }
 
 func (ipam IPAM) load() error {
 	if _, err := os.Stat(ipam.SubnetAllocatorPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
 
@@ -123,7 +128,8 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	}
 
 	c := 0
-	releaseIP := ipaddr.To4()
+	releaseIP := make(net.IP, net.IPv4len)
+	copy(releaseIP, ipaddr.To4())
 	releaseIP[3] -= 1
 	for t := uint(4); t > 0; t -= 1 {
 		c += int(releaseIP[t-1]-subnet.IP[t-1]) << ((4 - t) * 8)
@@ -139,3 +145,29 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	return nil
 
 }
+
+// Allocated returns the ip addresses handed out in the subnet.
+func (ipam *IPAM) Allocated(subnet *net.IPNet) ([]net.IP, error) {
+	ipam.Subnets = &map[string]string{}
+	if err := ipam.load(); err != nil {
+		return nil, err
+	}
+	_, subnet, err := net.ParseCIDR(subnet.String())
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for c, bit := range (*ipam.Subnets)[subnet.String()] {
+		if bit != '1' {
+			continue
+		}
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, subnet.IP.To4())
+		for t := uint(4); t > 0; t -= 1 {
+			ip[4-t] += uint8(c >> ((t - 1) * 8))
+		}
+		ip[3] += 1
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
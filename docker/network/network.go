@@ -70,7 +70,7 @@ func (nw *Network) dump(dumpPath string) error {
 	nwPath := path.Join(dumpPath, nw.Name)
 	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		logrus.Errorf("error: ", err)
+		logrus.Errorf("open network file, error: %v", err)
 		return err
 	}
 	defer nwFile.Close()
@@ -111,15 +111,15 @@ func (nw *Network) load(dumpPath string) error {
 	return nil
 }
 
-func Init() error {
+func Init(cfg *common.Config) error {
 	var bridgeDriver = BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = &bridgeDriver
-	if _, err := os.Stat(common.DefaultNetworkPath); err != nil && os.IsNotExist(err) {
-		if err = os.MkdirAll(common.DefaultNetworkPath, os.ModePerm); err != nil {
+	if _, err := os.Stat(cfg.NetworkPath()); err != nil && os.IsNotExist(err) {
+		if err = os.MkdirAll(cfg.NetworkPath(), os.ModePerm); err != nil {
 			return err
 		}
 	}
-	err := filepath.Walk(common.DefaultNetworkPath, func(nwPath string, info fs.FileInfo, err error) error {
+	err := filepath.Walk(cfg.NetworkPath(), func(nwPath string, info fs.FileInfo, err error) error {
 		if strings.HasSuffix(nwPath, "/") {
 			return nil
 		}
@@ -142,14 +142,14 @@ func Init() error {
 	return nil
 }
 
-func CreateNetwork(driver, subnet, name string) error {
+func CreateNetwork(cfg *common.Config, driver, subnet, name string) error {
 	_, ipNet, err := net.ParseCIDR(subnet)
 	if err != nil {
 		logrus.Errorf("parse cidr, err: %v", err)
 		return err
 	}
 
-	ip, err := ipAllocator.Allocate(ipNet)
+	ip, err := newIPAM(cfg).Allocate(ipNet)
 	if err != nil {
 		logrus.Errorf("allocate ip ,err :%v", err)
 		return err
@@ -161,7 +161,7 @@ func CreateNetwork(driver, subnet, name string) error {
 		return err
 	}
 
-	err = nw.dump(common.DefaultNetworkPath)
+	err = nw.dump(cfg.NetworkPath())
 	if err != nil {
 		logrus.Errorf("dump network, err:%v", err)
 		return err
@@ -169,16 +169,22 @@ func CreateNetwork(driver, subnet, name string) error {
 	return nil
 }
 
-func Connect(networkName string, containerInfo *container.ContainerInfo) error {
+func Connect(cfg *common.Config, networkName string, containerInfo *container.ContainerInfo) (err error) {
 	network, ok := networks[networkName]
 	if !ok {
 		return fmt.Errorf("no Such network: %s", networkName)
 	}
 
-	ip, err := ipAllocator.Allocate(network.IpRange)
+	rb := common.NewRollback()
+	defer rb.UnwindOnError(&err)
+
+	ip, err := newIPAM(cfg).Allocate(network.IpRange)
 	if err != nil {
 		return err
 	}
+	rb.Add("ip address", func() error {
+		return newIPAM(cfg).Release(network.IpRange, &ip)
+	})
 
 	ep := &Endpoint{
 		ID:          fmt.Sprintf("%s-%s", containerInfo.Id, networkName),
@@ -190,6 +196,9 @@ func Connect(networkName string, containerInfo *container.ContainerInfo) error {
 	if err = drivers[network.Driver].Connect(network, ep); err != nil {
 		return err
 	}
+	rb.Add("endpoint device", func() error {
+		return drivers[network.Driver].Disconnect(*network, ep)
+	})
 	if err = configEndpointIpAddressAndRoute(ep, containerInfo); err != nil {
 		return err
 	}
@@ -199,15 +208,69 @@ func Connect(networkName string, containerInfo *container.ContainerInfo) error {
 		logrus.Errorf("config port mapping, err: %v", err)
 		return err
 	}
+	containerInfo.Network = networkName
+	containerInfo.IPAddress = ip.String()
+	containerInfo.MacAddress = ep.MacAddress.String()
+	return nil
+}
+
+// Disconnect releases everything Connect set up for the container: the port
+// mapping rules, the veth pair and the allocated ip address.
+func Disconnect(cfg *common.Config, containerInfo *container.ContainerInfo) error {
+	if containerInfo.Network == "" {
+		return nil
+	}
+	network, ok := networks[containerInfo.Network]
+	if !ok {
+		return fmt.Errorf("no Such network: %s", containerInfo.Network)
+	}
+	ip := net.ParseIP(containerInfo.IPAddress)
+	if ip == nil {
+		return fmt.Errorf("invalid container ip address: %s", containerInfo.IPAddress)
+	}
+	ep := &Endpoint{
+		ID:          fmt.Sprintf("%s-%s", containerInfo.Id, containerInfo.Network),
+		IPAddress:   ip,
+		Network:     network,
+		PortMapping: containerInfo.PortMapping,
+	}
+	removePortMapping(ep)
+	if err := drivers[network.Driver].Disconnect(*network, ep); err != nil {
+		return fmt.Errorf("remove endpoint device, err: %v", err)
+	}
+	if err := newIPAM(cfg).Release(network.IpRange, &ip); err != nil {
+		return fmt.Errorf("release ip address, err: %v", err)
+	}
+	containerInfo.Network = ""
+	containerInfo.IPAddress = ""
+	containerInfo.MacAddress = ""
 	return nil
 }
 
+// Get reads a network from the state directory, without the set up Init
+// does.
+func Get(cfg *common.Config, networkName string) (*Network, error) {
+	if networkName == "" || strings.Contains(networkName, "/") || networkName == ".." {
+		return nil, fmt.Errorf("no such network: %s", networkName)
+	}
+	nw := &Network{Name: networkName}
+	if err := nw.load(path.Join(cfg.NetworkPath(), networkName)); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such network: %s", networkName)
+		}
+		return nil, err
+	}
+	return nw, nil
+}
+
 func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInfo) error {
 	peerLink, err := netlink.LinkByName(ep.Device.PeerName)
 	if err != nil {
 		logrus.Errorf("fail config endpoint: %v", err)
 		return err
 	}
+	// the address stays with the device when it moves into the container
+	ep.MacAddress = peerLink.Attrs().HardwareAddr
 	defer enterContainerNetns(&peerLink, cinfo)()
 
 	interfaceIP := *ep.Network.IpRange
@@ -262,21 +325,41 @@ func enterContainerNetns(enLink *netlink.Link, cinfo *container.ContainerInfo) f
 
 // 配置端口映射关系
 func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
-	for _, pm := range ep.PortMapping {
+	for i, pm := range ep.PortMapping {
 		portMapping := strings.Split(pm, ":")
 		if len(portMapping) != 2 {
 			logrus.Errorf("port mapping format error, %v", pm)
 			continue
 		}
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
-		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-		//err := cmd.Run()
-		output, err := cmd.Output()
-		if err != nil {
-			logrus.Errorf("iptables Output, %v", output)
+		if err := portMappingRule("-A", portMapping, ep.IPAddress); err != nil {
+			// drop the rules added so far, the caller unwinds the rest
+			removePortMapping(&Endpoint{IPAddress: ep.IPAddress, PortMapping: ep.PortMapping[:i]})
+			return err
+		}
+	}
+	return nil
+}
+
+func removePortMapping(ep *Endpoint) {
+	for _, pm := range ep.PortMapping {
+		portMapping := strings.Split(pm, ":")
+		if len(portMapping) != 2 {
 			continue
 		}
+		if err := portMappingRule("-D", portMapping, ep.IPAddress); err != nil {
+			logrus.Errorf("remove port mapping %s, err: %v", pm, err)
+		}
+	}
+}
+
+func portMappingRule(action string, portMapping []string, ip net.IP) error {
+	iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+		action, portMapping[0], ip.String(), portMapping[1])
+	cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		logrus.Errorf("iptables Output, %s", output)
+		return fmt.Errorf("iptables %s, err: %v", iptablesCmd, err)
 	}
 	return nil
 }
@@ -299,13 +382,13 @@ func ListNetwork() {
 }
 
 // 删除网络
-func DeleteNetwork(networkName string) error {
+func DeleteNetwork(cfg *common.Config, networkName string) error {
 	nw, ok := networks[networkName]
 	if !ok {
 		return fmt.Errorf("no Such Network: %s", networkName)
 	}
 
-	if err := ipAllocator.Release(nw.IpRange, &nw.IpRange.IP); err != nil {
+	if err := newIPAM(cfg).Release(nw.IpRange, &nw.IpRange.IP); err != nil {
 		return fmt.Errorf("remove network gateway ip, err: %v", err)
 	}
 
@@ -313,5 +396,5 @@ func DeleteNetwork(networkName string) error {
 		return fmt.Errorf("remove network driver, err: %v", err)
 	}
 
-	return nw.remove(common.DefaultNetworkPath)
+	return nw.remove(cfg.NetworkPath())
 }
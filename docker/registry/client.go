@@ -0,0 +1,276 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to registries. Tokens are cached per registry and scope, so
+// one client should serve a whole pull or push.
+type Client struct {
+	HTTPClient  *http.Client
+	Credentials Credentials
+	// Insecure registries are spoken to over plain http, loopback
+	// registries always are
+	Insecure []string
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func NewClient(creds Credentials) *Client {
+	return &Client{
+		HTTPClient:  &http.Client{Timeout: 30 * time.Minute},
+		Credentials: creds,
+		tokens:      map[string]string{},
+	}
+}
+
+// Error is an error response of the registry.
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("registry responded %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("registry responded %d %s, %s: %s", e.Status, http.StatusText(e.Status), e.Code, e.Detail)
+}
+
+// IsNotFound tells whether err is a registry response that something does
+// not exist.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Status == http.StatusNotFound
+}
+
+func responseError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	errs := struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}{}
+	e := &Error{Status: resp.StatusCode}
+	if json.Unmarshal(body, &errs) == nil && len(errs.Errors) > 0 {
+		e.Code = errs.Errors[0].Code
+		e.Detail = errs.Errors[0].Message
+	}
+	return e
+}
+
+// PullScope and PushScope are the token scopes of a repository.
+func PullScope(repository string) string {
+	return "repository:" + repository + ":pull"
+}
+
+func PushScope(repository string) string {
+	return "repository:" + repository + ":pull,push"
+}
+
+func (c *Client) baseURL(registry string) string {
+	scheme := "https"
+	host, _, err := net.SplitHostPort(registry)
+	if err != nil {
+		host = registry
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		scheme = "http"
+	}
+	for _, insecure := range c.Insecure {
+		if insecure == registry {
+			scheme = "http"
+		}
+	}
+	return scheme + "://" + registry
+}
+
+// url is the url of an api path of the repository of ref.
+func (c *Client) url(ref Reference, format string, args ...interface{}) string {
+	return c.baseURL(ref.Host()) + "/v2/" + ref.Repository + fmt.Sprintf(format, args...)
+}
+
+// do sends req with the token of the scopes. The first 401 answer starts
+// the auth handshake the registry asks for and req is sent once more.
+func (c *Client) do(ref Reference, req *http.Request, scopes ...string) (*http.Response, error) {
+	key := ref.Registry + " " + strings.Join(scopes, " ")
+	c.authorize(req, key)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if err := c.authenticate(ref.Registry, key, challenge, scopes); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	c.authorize(retry, key)
+	return c.HTTPClient.Do(retry)
+}
+
+func (c *Client) authorize(req *http.Request, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if auth, ok := c.tokens[key]; ok {
+		req.Header.Set("Authorization", auth)
+	}
+}
+
+// authenticate answers a Basic challenge with the credential of the
+// registry and a Bearer challenge with a token from the realm it names.
+func (c *Client) authenticate(registry, key, challenge string, scopes []string) error {
+	scheme, params := parseChallenge(challenge)
+	cred, hasCred := c.Credentials.Get(registry)
+	var auth string
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCred {
+			return fmt.Errorf("registry %s needs a login, add it to the credentials file", registry)
+		}
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(cred.Username+":"+cred.Password))
+	case "bearer":
+		token, err := c.fetchToken(params, scopes, cred, hasCred)
+		if err != nil {
+			return fmt.Errorf("get token of registry %s, err: %v", registry, err)
+		}
+		auth = "Bearer " + token
+	default:
+		return fmt.Errorf("registry %s asks for unsupported authentication %q", registry, challenge)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = map[string]string{}
+	}
+	c.tokens[key] = auth
+	return nil
+}
+
+func (c *Client) fetchToken(params map[string]string, scopes []string, cred Credential, hasCred bool) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return "", fmt.Errorf("invalid realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	for _, scope := range scopes {
+		query.Add("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if hasCred {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("the token response has no token")
+	}
+	return token.Token, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and its
+// parameters, values may be quoted and contain commas.
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return header, params
+	}
+	scheme, rest := header[:i], header[i+1:]
+	for {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return scheme, params
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.Replace(rest[1:min(end, len(rest))], `\`, "", -1)
+			rest = rest[min(end+1, len(rest)):]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimSpace(rest[:end])
+			rest = rest[end:]
+		}
+		params[name] = value
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testRegistry stands in for a registry, tests add the api handlers they
+// need to its mux.
+type testRegistry struct {
+	*httptest.Server
+	mux *http.ServeMux
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	mux := http.NewServeMux()
+	r := &testRegistry{Server: httptest.NewServer(mux), mux: mux}
+	t.Cleanup(r.Close)
+	return r
+}
+
+// ref names repository of the stand-in, a loopback registry is spoken to
+// over http.
+func (r *testRegistry) ref(t *testing.T, repository string) Reference {
+	ref, err := ParseReference(strings.TrimPrefix(r.URL, "http://") + "/" + repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestBearerTokenHandshake(t *testing.T) {
+	r := newTestRegistry(t)
+	var tokenRequests int32
+	r.mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		user, password, ok := req.BasicAuth()
+		if !ok || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if got := req.URL.Query().Get("service"); got != "test-registry" {
+			t.Errorf("token request for service %q", got)
+		}
+		if got := req.URL.Query().Get("scope"); got != "repository:team/app:pull" {
+			t.Errorf("token request for scope %q", got)
+		}
+		w.Write([]byte(`{"access_token":"t0k3n"}`))
+	})
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"` + MediaTypeDockerManifest + `"}`)
+	r.mux.HandleFunc("/v2/team/app/manifests/v1", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="test-registry",scope="repository:team/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeDockerManifest)
+		w.Write(manifest)
+	})
+
+	host := strings.TrimPrefix(r.URL, "http://")
+	client := NewClient(Credentials{host: {Username: "alice", Password: "secret"}})
+	ref := r.ref(t, "team/app:v1")
+	for i := 0; i < 2; i++ {
+		content, mediaType, digest, err := client.GetManifest(ref, "v1")
+		if err != nil {
+			t.Fatalf("get manifest, err: %v", err)
+		}
+		if string(content) != string(manifest) || mediaType != MediaTypeDockerManifest || digest != Digest(manifest) {
+			t.Fatalf("got manifest %s of type %s and digest %s", content, mediaType, digest)
+		}
+	}
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Fatalf("got %d token requests, the token should be cached", n)
+	}
+}
+
+func TestBearerTokenHandshakeWithoutCredentials(t *testing.T) {
+	r := newTestRegistry(t)
+	r.mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"login first"}]}`))
+	})
+	r.mux.HandleFunc("/v2/team/app/manifests/v1", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	_, _, _, err := NewClient(Credentials{}).GetManifest(r.ref(t, "team/app:v1"), "v1")
+	if err == nil || !strings.Contains(err.Error(), "UNAUTHORIZED") {
+		t.Fatalf("got err %v, want the error of the token server", err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+	if scheme != "Bearer" {
+		t.Fatalf("got scheme %s", scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull,push",
+	}
+	for name, value := range want {
+		if params[name] != value {
+			t.Errorf("got %s=%q, want %q", name, params[name], value)
+		}
+	}
+}
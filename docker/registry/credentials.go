@@ -0,0 +1,105 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Credential is a user name and password of a registry.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Credentials are keyed by registry host, as in the auths section of the
+// config.json of docker.
+type Credentials map[string]Credential
+
+type credentialsFile struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// DefaultCredentialsFile is where docker login stores credentials.
+func DefaultCredentialsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return path.Join(home, ".docker", "config.json")
+}
+
+// LoadCredentials reads a credentials file in the format of the config.json
+// of docker. A missing file has no credentials.
+func LoadCredentials(filePath string) (Credentials, error) {
+	creds := Credentials{}
+	if filePath == "" {
+		return creds, nil
+	}
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return creds, nil
+		}
+		return nil, err
+	}
+	file := &credentialsFile{}
+	if err := json.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("parse credentials file %s, err: %v", filePath, err)
+	}
+	for key, auth := range file.Auths {
+		cred := Credential{Username: auth.Username, Password: auth.Password}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of %s in %s, err: %v", key, filePath, err)
+			}
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid auth of %s in %s, should be user:password", key, filePath)
+			}
+			cred = Credential{Username: parts[0], Password: parts[1]}
+		}
+		creds[credentialsKey(key)] = cred
+	}
+	return creds, nil
+}
+
+// credentialsKey reduces the keys docker writes, which may be urls, to
+// registry names.
+func credentialsKey(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key = strings.SplitN(key, "/", 2)[0]
+	if key == "index.docker.io" || key == dockerHubHost {
+		return DefaultRegistry
+	}
+	return key
+}
+
+// Get returns the credential of a registry.
+func (c Credentials) Get(registry string) (Credential, bool) {
+	cred, ok := c[registry]
+	return cred, ok
+}
@@ -0,0 +1,201 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	// maxManifestSize bounds what is read of a manifest response
+	maxManifestSize = 4 * 1024 * 1024
+	// fetchAttempts is how often an interrupted blob download is resumed
+	fetchAttempts = 3
+)
+
+var manifestMediaTypes = []string{
+	v1.MediaTypeImageManifest,
+	v1.MediaTypeImageIndex,
+	MediaTypeDockerManifest,
+	MediaTypeDockerManifestList,
+}
+
+// IsIndex tells whether a manifest media type lists the manifests of
+// several platforms.
+func IsIndex(mediaType string) bool {
+	return mediaType == v1.MediaTypeImageIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// Digest is the sha256 digest of content.
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// GetManifest fetches a manifest or an index by tag or digest and returns
+// it with its media type and digest. Manifests fetched by digest are
+// verified.
+func (c *Client) GetManifest(ref Reference, reference string) ([]byte, string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url(ref, "/manifests/%s", reference), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	resp, err := c.do(ref, req, PullScope(ref.Repository))
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("get manifest %s of %s, err: %v", reference, ref.Name(), responseError(resp))
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, "", "", err
+	}
+	digest := Digest(content)
+	if strings.HasPrefix(reference, "sha256:") && digest != reference {
+		return nil, "", "", fmt.Errorf("manifest %s of %s does not match its digest", reference, ref.Name())
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !contains(manifestMediaTypes, mediaType) {
+		// some registries answer with a generic type, the manifest knows
+		versioned := struct {
+			SchemaVersion int    `json:"schemaVersion"`
+			MediaType     string `json:"mediaType"`
+		}{}
+		if err := json.Unmarshal(content, &versioned); err != nil {
+			return nil, "", "", fmt.Errorf("parse manifest %s of %s, err: %v", reference, ref.Name(), err)
+		}
+		mediaType = versioned.MediaType
+		if versioned.SchemaVersion == 1 {
+			return nil, "", "", fmt.Errorf("manifest %s of %s uses the deprecated schema 1", reference, ref.Name())
+		}
+		if mediaType == "" {
+			mediaType = v1.MediaTypeImageManifest
+			if strings.Contains(string(content), `"manifests"`) {
+				mediaType = v1.MediaTypeImageIndex
+			}
+		}
+	}
+	return content, mediaType, digest, nil
+}
+
+// FetchBlob downloads a blob to dest and verifies it. The content of dest
+// is taken as the start of the blob, so an interrupted download resumes
+// where it stopped.
+func (c *Client) FetchBlob(ref Reference, desc v1.Descriptor, dest string) error {
+	var err error
+	for attempt := 0; attempt < fetchAttempts; attempt++ {
+		var resumable bool
+		resumable, err = c.fetchBlob(ref, desc, dest)
+		if err == nil || !resumable {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if "sha256:"+hex.EncodeToString(hash.Sum(nil)) != desc.Digest.String() {
+		_ = os.Remove(dest)
+		return fmt.Errorf("blob %s of %s does not match its digest", desc.Digest, ref.Name())
+	}
+	return nil
+}
+
+// fetchBlob downloads the part of the blob which dest lacks, it tells
+// whether a failure is worth resuming.
+func (c *Client) fetchBlob(ref Reference, desc v1.Descriptor, dest string) (bool, error) {
+	var offset int64
+	if fi, err := os.Stat(dest); err == nil {
+		offset = fi.Size()
+	}
+	if desc.Size > 0 && offset >= desc.Size {
+		if offset == desc.Size {
+			return false, nil
+		}
+		offset = 0
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.url(ref, "/blobs/%s", desc.Digest), nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.do(ref, req, PullScope(ref.Repository))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// the registry ignored the range, start over
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		_ = os.Remove(dest)
+		return true, fmt.Errorf("resume blob %s of %s, err: %v", desc.Digest, ref.Name(), responseError(resp))
+	default:
+		return false, fmt.Errorf("get blob %s of %s, err: %v", desc.Digest, ref.Name(), responseError(resp))
+	}
+	f, err := os.OpenFile(dest, flags, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return true, fmt.Errorf("download blob %s of %s, err: %v", desc.Digest, ref.Name(), err)
+	}
+	return false, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package registry
+
+import (
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGetManifestRejectsDigestMismatch(t *testing.T) {
+	r := newTestRegistry(t)
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"` + v1.MediaTypeImageManifest + `"}`)
+	wanted := Digest([]byte(`{"schemaVersion":2}`))
+	r.mux.HandleFunc("/v2/team/app/manifests/"+wanted, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+		w.Write(manifest)
+	})
+	_, _, _, err := NewClient(Credentials{}).GetManifest(r.ref(t, "team/app"), wanted)
+	if err == nil || !strings.Contains(err.Error(), "does not match its digest") {
+		t.Fatalf("got err %v, want a digest mismatch", err)
+	}
+}
+
+func TestFetchBlobRejectsDigestMismatch(t *testing.T) {
+	r := newTestRegistry(t)
+	blob := []byte("the blob")
+	desc := v1.Descriptor{Digest: digest.Digest(Digest([]byte("another blob"))), Size: int64(len(blob))}
+	r.mux.HandleFunc("/v2/team/app/blobs/"+desc.Digest.String(), func(w http.ResponseWriter, req *http.Request) {
+		w.Write(blob)
+	})
+	dest := path.Join(t.TempDir(), "blob")
+	err := NewClient(Credentials{}).FetchBlob(r.ref(t, "team/app"), desc, dest)
+	if err == nil || !strings.Contains(err.Error(), "does not match its digest") {
+		t.Fatalf("got err %v, want a digest mismatch", err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("the mismatching blob is kept, stat err: %v", err)
+	}
+}
+
+// serveRanges serves blob honouring Range headers, it records the ranges
+// asked for. The first response is cut off after cutAt bytes if cutAt is
+// positive.
+func serveRanges(r *testRegistry, blob []byte, cutAt int) (desc v1.Descriptor, ranges func() []string) {
+	desc = v1.Descriptor{Digest: digest.Digest(Digest(blob)), Size: int64(len(blob))}
+	var (
+		mu     sync.Mutex
+		asked  []string
+		served int
+	)
+	r.mux.HandleFunc("/v2/team/app/blobs/"+desc.Digest.String(), func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		asked = append(asked, req.Header.Get("Range"))
+		served++
+		first := served == 1
+		mu.Unlock()
+
+		offset := 0
+		if rng := req.Header.Get("Range"); rng != "" {
+			start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			if err != nil || start >= len(blob) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			offset = start
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(blob)-1, len(blob)))
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)-offset))
+		if offset > 0 {
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		if first && cutAt > 0 {
+			w.Write(blob[offset:cutAt])
+			w.(http.Flusher).Flush()
+			// the client sees the connection drop in the middle of the body
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(blob[offset:])
+	})
+	return desc, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), asked...)
+	}
+}
+
+func TestFetchBlobResumesPartialDownload(t *testing.T) {
+	r := newTestRegistry(t)
+	blob := []byte(strings.Repeat("0123456789", 100))
+	desc, ranges := serveRanges(r, blob, 0)
+	dest := path.Join(t.TempDir(), "blob")
+	// a previous pull stopped after 300 bytes
+	if err := ioutil.WriteFile(dest, blob[:300], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewClient(Credentials{}).FetchBlob(r.ref(t, "team/app"), desc, dest); err != nil {
+		t.Fatalf("fetch blob, err: %v", err)
+	}
+	content, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != string(blob) {
+		t.Fatalf("got %d bytes of blob, want %d", len(content), len(blob))
+	}
+	if got := ranges(); len(got) != 1 || got[0] != "bytes=300-" {
+		t.Fatalf("got requests for ranges %q, want one for bytes=300-", got)
+	}
+}
+
+func TestFetchBlobResumesInterruptedDownload(t *testing.T) {
+	r := newTestRegistry(t)
+	blob := []byte(strings.Repeat("abcdefghij", 1000))
+	desc, ranges := serveRanges(r, blob, 4000)
+	dest := path.Join(t.TempDir(), "blob")
+	if err := NewClient(Credentials{}).FetchBlob(r.ref(t, "team/app"), desc, dest); err != nil {
+		t.Fatalf("fetch blob, err: %v", err)
+	}
+	content, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != string(blob) {
+		t.Fatalf("got %d bytes of blob, want %d", len(content), len(blob))
+	}
+	got := ranges()
+	if len(got) != 2 || got[0] != "" || got[1] != "bytes=4000-" {
+		t.Fatalf("got requests for ranges %q, want the whole blob and bytes=4000-", got)
+	}
+}
+
+func TestFetchBlobStartsOverWhenRangeIsIgnored(t *testing.T) {
+	r := newTestRegistry(t)
+	blob := []byte("a blob served whole")
+	desc := v1.Descriptor{Digest: digest.Digest(Digest(blob)), Size: int64(len(blob))}
+	r.mux.HandleFunc("/v2/team/app/blobs/"+desc.Digest.String(), func(w http.ResponseWriter, req *http.Request) {
+		w.Write(blob)
+	})
+	dest := path.Join(t.TempDir(), "blob")
+	if err := ioutil.WriteFile(dest, []byte("a blob"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewClient(Credentials{}).FetchBlob(r.ref(t, "team/app"), desc, dest); err != nil {
+		t.Fatalf("fetch blob, err: %v", err)
+	}
+	content, _ := ioutil.ReadFile(dest)
+	if string(content) != string(blob) {
+		t.Fatalf("got blob %q, want %q", content, blob)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// DefaultChunkSize is the size above which blobs are uploaded in chunks.
+const DefaultChunkSize = 32 * 1024 * 1024
+
+// BlobExists tells whether the repository of ref has a blob.
+func (c *Client) BlobExists(ref Reference, digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.url(ref, "/blobs/%s", digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(ref, req, PushScope(ref.Repository))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("check blob %s of %s, err: %v", digest, ref.Name(), responseError(resp))
+}
+
+// MountBlob asks the registry to link a blob of another of its repositories
+// into the repository of ref, which saves the upload. Registries not
+// supporting it, or refusing it, are left without an upload in progress.
+func (c *Client) MountBlob(ref Reference, digest, fromRepository string) (bool, error) {
+	query := url.Values{}
+	query.Set("mount", digest)
+	query.Set("from", fromRepository)
+	req, err := http.NewRequest(http.MethodPost, c.url(ref, "/blobs/uploads/?%s", query.Encode()), nil)
+	if err != nil {
+		return false, err
+	}
+	scopes := []string{PushScope(ref.Repository), PullScope(fromRepository)}
+	resp, err := c.do(ref, req, scopes...)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		// an upload was started instead, it is not needed
+		if location, err := uploadLocation(resp); err == nil {
+			if cancel, err := http.NewRequest(http.MethodDelete, location, nil); err == nil {
+				if resp, err := c.do(ref, cancel, scopes...); err == nil {
+					resp.Body.Close()
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("mount blob %s into %s, err: %v", digest, ref.Name(), responseError(resp))
+}
+
+// UploadBlob uploads size bytes of r as the blob digest, in one request up
+// to chunkSize and in chunks of chunkSize beyond.
+func (c *Client) UploadBlob(ref Reference, r io.ReaderAt, size int64, digest string, chunkSize int64) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url(ref, "/blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ref, req, PushScope(ref.Repository))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start upload of blob %s to %s, err: %v", digest, ref.Name(), responseError(resp))
+	}
+	location, err := uploadLocation(resp)
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	if size > chunkSize {
+		for offset < size {
+			n := chunkSize
+			if offset+n > size {
+				n = size - offset
+			}
+			req, err := sectionRequest(http.MethodPatch, location, r, offset, n)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+n-1))
+			resp, err := c.do(ref, req, PushScope(ref.Repository))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				return fmt.Errorf("upload chunk of blob %s to %s, err: %v", digest, ref.Name(), responseError(resp))
+			}
+			if location, err = uploadLocation(resp); err != nil {
+				return err
+			}
+			offset += n
+		}
+	}
+
+	finish, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := finish.Query()
+	query.Set("digest", digest)
+	finish.RawQuery = query.Encode()
+	req, err = sectionRequest(http.MethodPut, finish.String(), r, offset, size-offset)
+	if err != nil {
+		return err
+	}
+	resp, err = c.do(ref, req, PushScope(ref.Repository))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("finish upload of blob %s to %s, err: %v", digest, ref.Name(), responseError(resp))
+	}
+	return nil
+}
+
+// PutManifest uploads a manifest under a tag or its digest and returns its
+// digest.
+func (c *Client) PutManifest(ref Reference, reference, mediaType string, content []byte) (string, error) {
+	req, err := http.NewRequest(http.MethodPut, c.url(ref, "/manifests/%s", reference), bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(ref, req, PushScope(ref.Repository))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("put manifest %s of %s, err: %v", reference, ref.Name(), responseError(resp))
+	}
+	return Digest(content), nil
+}
+
+// sectionRequest sends n bytes of r from offset, the body can be sent once
+// more after an auth handshake.
+func sectionRequest(method, location string, r io.ReaderAt, offset, n int64) (*http.Request, error) {
+	req, err := http.NewRequest(method, location, io.NewSectionReader(r, offset, n))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = n
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(r, offset, n)), nil
+	}
+	if n == 0 {
+		req.Body = http.NoBody
+		req.GetBody = func() (io.ReadCloser, error) {
+			return http.NoBody, nil
+		}
+	}
+	return req, nil
+}
+
+// uploadLocation is where an upload continues, registries may answer with
+// a path relative to the request.
+func uploadLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("registry answered the upload without a location")
+	}
+	target, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %s, err: %v", location, err)
+	}
+	return resp.Request.URL.ResolveReference(target).String(), nil
+}
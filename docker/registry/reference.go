@@ -0,0 +1,121 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package registry is a client of the OCI distribution api, which docker
+// registries and most other registries serve.
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultRegistry is used for references without a registry
+	DefaultRegistry = "docker.io"
+	// dockerHubHost serves the api of DefaultRegistry
+	dockerHubHost  = "registry-1.docker.io"
+	officialPrefix = "library/"
+	defaultTag     = "latest"
+)
+
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern        = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestPattern     = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Reference names an image of a registry by tag or by digest.
+type Reference struct {
+	// Registry is a host with an optional port
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference accepts [registry/]repository[:tag|@digest]. The first
+// component is a registry if it has a dot or a port or is localhost, docker
+// hub is used otherwise and single component repositories are official
+// images.
+func ParseReference(ref string) (Reference, error) {
+	r := Reference{}
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		r.Digest = name[i+1:]
+		name = name[:i]
+		if !digestPattern.MatchString(r.Digest) {
+			return r, fmt.Errorf("invalid reference %s, unsupported digest %s", ref, r.Digest)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		r.Tag = name[i+1:]
+		name = name[:i]
+		if !tagPattern.MatchString(r.Tag) {
+			return r, fmt.Errorf("invalid reference %s, invalid tag %s", ref, r.Tag)
+		}
+	}
+	if i := strings.Index(name, "/"); i >= 0 && (strings.ContainsAny(name[:i], ".:") || name[:i] == "localhost") {
+		r.Registry = name[:i]
+		name = name[i+1:]
+	} else {
+		r.Registry = DefaultRegistry
+		if !strings.Contains(name, "/") {
+			name = officialPrefix + name
+		}
+	}
+	if !repositoryPattern.MatchString(name) {
+		return r, fmt.Errorf("invalid reference %s, invalid repository %s", ref, name)
+	}
+	r.Repository = name
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = defaultTag
+	}
+	return r, nil
+}
+
+// Name is the registry and the repository in their short form, docker hub
+// and its official images are left out as they usually are.
+func (r Reference) Name() string {
+	if r.Registry == DefaultRegistry {
+		return strings.TrimPrefix(r.Repository, officialPrefix)
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// String is the short form of the reference, preferring the digest.
+func (r Reference) String() string {
+	if r.Digest != "" {
+		return r.Name() + "@" + r.Digest
+	}
+	return r.Name() + ":" + r.Tag
+}
+
+// Reference is the tag or digest the manifest is requested by.
+func (r Reference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// Host serves the api of the registry.
+func (r Reference) Host() string {
+	if r.Registry == DefaultRegistry {
+		return dockerHubHost
+	}
+	return r.Registry
+}
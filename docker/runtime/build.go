@@ -0,0 +1,772 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/dockerfile"
+	"github.com/go-kinds/docker/image"
+	"github.com/go-kinds/docker/storage"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildNamespaces isolate RUN steps from the host but its network, package
+// managers have to reach their mirrors.
+var buildNamespaces = []string{"pid", "mount", "ipc", "uts"}
+
+// BuildOptions tune Build. Dockerfile defaults to the Dockerfile in Context,
+// BuildArgs set the ARGs of the Dockerfile. Progress gets the steps and the
+// output of the RUN instructions if set.
+type BuildOptions struct {
+	Dockerfile string
+	Context    string
+	Tags       []string
+	BuildArgs  map[string]string
+	NoCache    bool
+	Progress   io.Writer
+}
+
+// builder holds the image a Dockerfile builds while its instructions are
+// dispatched one by one.
+type builder struct {
+	r       *Runtime
+	store   *image.Store
+	opts    BuildOptions
+	out     io.Writer
+	owner   string
+	started bool
+	config  *v1.Image
+	layers  []string
+	// args are the declared ARGs with a value, metaArgs those before FROM
+	args     map[string]string
+	metaArgs map[string]string
+	usedArgs map[string]bool
+}
+
+// Build builds an image from a Dockerfile. FROM, RUN, COPY, ADD, ENV,
+// WORKDIR, USER, CMD, ENTRYPOINT, EXPOSE, LABEL and ARG are understood, a
+// Dockerfile has a single stage. Each RUN is run in a container of the
+// image built so far, its write layer becomes the next layer. Layers are
+// cached by the layers below them and the instruction, COPY and ADD by the
+// content of the files as well.
+func (r *Runtime) Build(opts BuildOptions) (*image.Image, error) {
+	contextDir, err := filepath.Abs(opts.Context)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(contextDir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("build context %s is not a directory", opts.Context)
+	}
+	opts.Context = contextDir
+	if opts.Dockerfile == "" {
+		opts.Dockerfile = path.Join(contextDir, "Dockerfile")
+	}
+	f, err := os.Open(opts.Dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	instructions, err := dockerfile.Parse(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("%s has no instructions", opts.Dockerfile)
+	}
+
+	b := &builder{
+		r:        r,
+		store:    r.ImageStore(),
+		opts:     opts,
+		out:      opts.Progress,
+		owner:    image.BuildOwner(container.RandStringBytes(10)),
+		args:     map[string]string{},
+		metaArgs: map[string]string{},
+		usedArgs: map[string]bool{},
+	}
+	if b.out == nil {
+		b.out = ioutil.Discard
+	}
+	defer func() {
+		if err := b.store.EndBuild(b.owner, b.layers); err != nil {
+			logrus.Errorf("drop layer references of the build, err: %v", err)
+		}
+	}()
+
+	for i, instruction := range instructions {
+		fmt.Fprintf(b.out, "Step %d/%d : %s\n", i+1, len(instructions), instruction.Original)
+		if err := b.dispatch(instruction); err != nil {
+			return nil, fmt.Errorf("line %d: %v", instruction.Line, err)
+		}
+	}
+	if !b.started {
+		return nil, fmt.Errorf("%s has no FROM instruction", opts.Dockerfile)
+	}
+
+	var unused []string
+	for name := range opts.BuildArgs {
+		if !b.usedArgs[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		fmt.Fprintf(b.out, "[Warning] One or more build args %v were not consumed\n", unused)
+	}
+
+	created := time.Now().UTC()
+	b.config.Created = &created
+	img, err := b.store.Create(b.config, b.layers, opts.Tags...)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(b.out, "Successfully built %s\n", shortDigest(img.ID))
+	for _, tag := range opts.Tags {
+		fmt.Fprintf(b.out, "Successfully tagged %s\n", image.NormalizeReference(tag))
+	}
+	return img, nil
+}
+
+func (b *builder) dispatch(instruction dockerfile.Instruction) error {
+	if instruction.Command == "ARG" {
+		return b.arg(instruction)
+	}
+	if instruction.Command == "FROM" {
+		return b.from(instruction)
+	}
+	if !b.started {
+		return fmt.Errorf("%s before FROM", instruction.Command)
+	}
+
+	switch instruction.Command {
+	case "RUN":
+		return b.run(instruction)
+	case "COPY", "ADD":
+		return b.copy(instruction)
+	case "CMD", "ENTRYPOINT":
+		// the shell of the container expands the command
+		return b.change(instruction, instruction.Args)
+	case "ENV", "LABEL", "WORKDIR", "USER", "EXPOSE":
+		args, err := dockerfile.Expand(instruction.Args, b.lookup)
+		if err != nil {
+			return err
+		}
+		return b.change(instruction, args)
+	}
+	return fmt.Errorf("unsupported instruction %s", instruction.Command)
+}
+
+// arg declares a build argument, the value given to the build wins over the
+// default of the Dockerfile.
+func (b *builder) arg(instruction dockerfile.Instruction) error {
+	fields := strings.SplitN(instruction.Args, "=", 2)
+	name := strings.TrimSpace(fields[0])
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("ARG needs a single name[=default]")
+	}
+	args := b.args
+	if !b.started {
+		args = b.metaArgs
+	}
+	if value, ok := b.opts.BuildArgs[name]; ok {
+		args[name] = value
+		b.usedArgs[name] = true
+	} else if len(fields) == 2 {
+		value, err := dockerfile.Expand(fields[1], b.lookup)
+		if err != nil {
+			return err
+		}
+		args[name] = strings.Trim(value, `"`)
+	} else if value, ok := b.metaArgs[name]; ok && b.started {
+		// redeclaring an ARG of before FROM makes it usable in the stage
+		args[name] = value
+	}
+	return b.history(instruction, true)
+}
+
+func (b *builder) from(instruction dockerfile.Instruction) error {
+	if b.started {
+		return fmt.Errorf("multi-stage builds are not supported")
+	}
+	args, err := dockerfile.Expand(instruction.Args, func(name string) (string, bool) {
+		value, ok := b.metaArgs[name]
+		return value, ok
+	})
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(args)
+	if len(fields) != 1 && !(len(fields) == 3 && strings.EqualFold(fields[1], "AS")) {
+		return fmt.Errorf("FROM needs an image and optionally AS name")
+	}
+	b.started = true
+	if fields[0] == "scratch" {
+		b.config = &v1.Image{}
+		return nil
+	}
+
+	base, err := b.r.GetImage(fields[0])
+	if err != nil {
+		base, err = b.r.PullImage(fields[0], PullOptions{Progress: b.out})
+		if err != nil {
+			return err
+		}
+	}
+	// the config of the store is shared, the build changes a copy of it
+	content, err := json.Marshal(base.Config)
+	if err != nil {
+		return err
+	}
+	b.config = &v1.Image{}
+	if err := json.Unmarshal(content, b.config); err != nil {
+		return err
+	}
+	if err := b.store.AcquireLayers(b.owner, base.Layers); err != nil {
+		return err
+	}
+	b.layers = append([]string(nil), base.Layers...)
+	fmt.Fprintf(b.out, " ---> %s\n", shortDigest(base.ID))
+	return nil
+}
+
+// change applies an instruction which only changes the config.
+func (b *builder) change(instruction dockerfile.Instruction, args string) error {
+	if err := image.ApplyChange(&b.config.Config, instruction.Command+" "+args); err != nil {
+		return err
+	}
+	return b.history(instruction, true)
+}
+
+func (b *builder) history(instruction dockerfile.Instruction, emptyLayer bool) error {
+	if !b.started {
+		return nil
+	}
+	created := time.Now().UTC()
+	b.config.History = append(b.config.History, v1.History{
+		Created:    &created,
+		CreatedBy:  "/bin/sh -c #(nop) " + instruction.Original,
+		EmptyLayer: emptyLayer,
+	})
+	return nil
+}
+
+// lookup expands variables, ENV wins over ARG.
+func (b *builder) lookup(name string) (string, bool) {
+	if value, ok := b.lookupEnv(name); ok {
+		return value, true
+	}
+	if !b.started {
+		value, ok := b.metaArgs[name]
+		return value, ok
+	}
+	value, ok := b.args[name]
+	return value, ok
+}
+
+// addLayer puts a layer on top of the image, cached under key unless it
+// came from the cache.
+func (b *builder) addLayer(layer *image.Layer, key string, cached bool) error {
+	if err := b.store.AcquireLayers(b.owner, []string{layer.DiffID}); err != nil {
+		return err
+	}
+	b.layers = append(b.layers, layer.DiffID)
+	if cached {
+		fmt.Fprintln(b.out, " ---> Using cache")
+	} else if err := b.store.CacheLayer(key, layer.DiffID); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.out, " ---> %s\n", shortDigest(layer.DiffID))
+	return nil
+}
+
+func (b *builder) cachedLayer(key string) (*image.Layer, bool) {
+	if b.opts.NoCache {
+		return nil, false
+	}
+	return b.store.CachedLayer(key)
+}
+
+func (b *builder) run(instruction dockerfile.Instruction) error {
+	// a container needs a root file system to run in
+	if len(b.layers) == 0 {
+		return fmt.Errorf("RUN needs a root file system, the image FROM scratch has no layers yet, COPY or ADD one first")
+	}
+	cmd, ok := instruction.JSONArgs()
+	if !ok {
+		cmd = []string{"/bin/sh", "-c", instruction.Args}
+	}
+	// ARGs are in the environment of RUN, not in the one of the image
+	env := append([]string(nil), b.config.Config.Env...)
+	var names []string
+	for name := range b.args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, inEnv := b.lookupEnv(name); !inEnv {
+			env = append(env, name+"="+b.args[name])
+		}
+	}
+
+	cmdJSON, _ := json.Marshal(cmd)
+	envJSON, _ := json.Marshal(env)
+	key := image.CacheKey("RUN", strings.Join(b.layers, ","), string(cmdJSON), string(envJSON),
+		b.config.Config.User, b.config.Config.WorkingDir)
+	layer, cached := b.cachedLayer(key)
+	if !cached {
+		var err error
+		if layer, err = b.runContainer(cmd, env); err != nil {
+			return err
+		}
+	}
+	if err := b.addLayer(layer, key, cached); err != nil {
+		return err
+	}
+	created := time.Now().UTC()
+	b.config.History = append(b.config.History, v1.History{
+		Created:   &created,
+		CreatedBy: strings.Join(cmd, " "),
+	})
+	return nil
+}
+
+func (b *builder) lookupEnv(name string) (string, bool) {
+	if b.config == nil {
+		return "", false
+	}
+	for _, kv := range b.config.Config.Env {
+		if fields := strings.SplitN(kv, "=", 2); fields[0] == name && len(fields) == 2 {
+			return fields[1], true
+		}
+	}
+	return "", false
+}
+
+// runContainer runs a command in a container of the image built so far and
+// stores its write layer.
+func (b *builder) runContainer(cmd, env []string) (*image.Layer, error) {
+	images, err := b.store.List()
+	if err != nil {
+		return nil, err
+	}
+	config := *b.config
+	img, err := b.store.Create(&config, b.layers)
+	if err != nil {
+		return nil, err
+	}
+	// the image is only there to start the container from, unless it was
+	// there before, e.g. the base image itself
+	existed := false
+	for _, other := range images {
+		existed = existed || other.ID == img.ID
+	}
+	if !existed {
+		defer func() {
+			if _, err := b.store.Remove(img.ID, true); err != nil {
+				logrus.Errorf("remove intermediate image %s, err: %v", img.ID, err)
+			}
+		}()
+	}
+
+	info, err := b.r.Create(&ContainerSpec{
+		Name:  "build-" + container.RandStringBytes(10),
+		Image: img.ID,
+		// RUN bypasses the entrypoint of the image
+		Entrypoint: []string{},
+		Cmd:        cmd,
+		Env:        env,
+		Namespaces: buildNamespaces,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := b.r.Delete(info.Name, true); err != nil {
+			logrus.Errorf("delete build container %s, err: %v", info.Name, err)
+		}
+	}()
+	fmt.Fprintf(b.out, " ---> Running in %s\n", info.Name)
+
+	// attach before starting, so no output is missed
+	conn, err := b.r.Attach(info.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.r.Start(info.Name); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_, _ = io.Copy(b.out, conn)
+	conn.Close()
+	code, err := b.r.Wait(info.Name)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("the command '%s' returned a non-zero code: %d", strings.Join(cmd, " "), code)
+	}
+
+	driver, err := storage.Get(b.r.Config.StorageDriver)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Diff(container.WriteLayerDiff(b.r.Config, info.Name), pw, driver))
+	}()
+	defer pr.Close()
+	return b.store.PutLayer(pr)
+}
+
+// copy adds files of the build context as a layer. ADD extracts local tar
+// archives, compressed or not, into the destination directory.
+func (b *builder) copy(instruction dockerfile.Instruction) error {
+	if _, ok := instruction.Flags["from"]; ok {
+		return fmt.Errorf("%s --from is not supported, a Dockerfile has a single stage", instruction.Command)
+	}
+	uid, gid, err := parseChown(instruction.Flags["chown"])
+	if err != nil {
+		return err
+	}
+	args, ok := instruction.JSONArgs()
+	if !ok {
+		expanded, err := dockerfile.Expand(instruction.Args, b.lookup)
+		if err != nil {
+			return err
+		}
+		if args, err = dockerfile.Words(expanded); err != nil {
+			return err
+		}
+	} else {
+		for i := range args {
+			if args[i], err = dockerfile.Expand(args[i], b.lookup); err != nil {
+				return err
+			}
+		}
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("%s needs a source and a destination", instruction.Command)
+	}
+	sources, dest := args[:len(args)-1], args[len(args)-1]
+	// like docker, . and directories of the image are destination
+	// directories as well
+	destIsDir := strings.HasSuffix(dest, "/") || dest == "." || strings.HasSuffix(dest, "/.")
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", b.config.Config.WorkingDir, dest)
+	}
+	dest = path.Clean(dest)
+	if !destIsDir {
+		if destIsDir, err = b.isImageDir(dest); err != nil {
+			return err
+		}
+	}
+
+	var files []string
+	for _, source := range sources {
+		if strings.Contains(source, "://") {
+			return fmt.Errorf("%s of urls is not supported: %s", instruction.Command, source)
+		}
+		matches, err := b.contextFiles(source)
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) > 1 && !destIsDir {
+		return fmt.Errorf("%s of several files needs a destination directory", instruction.Command)
+	}
+
+	tmp, err := ioutil.TempFile("", "go-docker-build-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	w := &layerWriter{tw: tar.NewWriter(tmp), hash: sha256.New(), uid: uid, gid: gid}
+	for _, file := range files {
+		// symlinks are copied as links, never followed out of the context
+		fi, err := os.Lstat(file)
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			// the content of a directory is copied, not the directory
+			err = w.addDir(file, dest)
+		case instruction.Command == "ADD" && fi.Mode().IsRegular() && isArchive(file):
+			err = w.addArchive(file, dest)
+		case destIsDir:
+			err = w.addFile(file, path.Join(dest, fi.Name()), fi)
+		default:
+			err = w.addFile(file, dest, fi)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s, err: %v", instruction.Command, file, err)
+		}
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+
+	key := image.CacheKey(instruction.Command, strings.Join(b.layers, ","), dest,
+		instruction.Flags["chown"], hex.EncodeToString(w.hash.Sum(nil)))
+	layer, cached := b.cachedLayer(key)
+	if !cached {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if layer, err = b.store.PutLayer(tmp); err != nil {
+			return err
+		}
+	}
+	if err := b.addLayer(layer, key, cached); err != nil {
+		return err
+	}
+	return b.history(instruction, false)
+}
+
+// isImageDir reports whether name is a directory in the image built so
+// far. The layers are searched from the top, a whiteout or an opaque
+// directory hides what is below.
+func (b *builder) isImageDir(name string) (bool, error) {
+	if name == "/" {
+		return true, nil
+	}
+	driver, err := storage.Get(b.r.Config.StorageDriver)
+	if err != nil {
+		return false, err
+	}
+	for i := len(b.layers) - 1; i >= 0; i-- {
+		dir := b.store.LayerDir(b.layers[i])
+		for p := name; p != "/"; p = path.Dir(p) {
+			// aufs keeps whiteouts as files next to what they delete
+			wh := filepath.Join(dir, path.Dir(p), archive.WhiteoutPrefix+path.Base(p))
+			if _, err := os.Lstat(wh); err == nil {
+				return false, nil
+			}
+			fi, err := os.Lstat(filepath.Join(dir, p))
+			if err != nil {
+				continue
+			}
+			if p == name || !fi.IsDir() || driver.IsWhiteout(fi) {
+				return p == name && fi.IsDir(), nil
+			}
+			opaque, err := driver.IsOpaque(filepath.Join(dir, p))
+			if err != nil {
+				return false, err
+			}
+			if _, err := os.Lstat(filepath.Join(dir, p, archive.WhiteoutOpaqueDir)); opaque || err == nil {
+				return false, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// contextFiles expands a source of COPY to the files of the build context
+// it matches.
+func (b *builder) contextFiles(source string) ([]string, error) {
+	name := filepath.Clean(strings.TrimPrefix(source, "/"))
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return nil, fmt.Errorf("%s is outside of the build context", source)
+	}
+	matches, err := filepath.Glob(filepath.Join(b.opts.Context, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no such file or directory in the build context", source)
+	}
+	// the directories of a match may be symlinks, they have to stay in the
+	// context
+	context, err := filepath.EvalSymlinks(b.opts.Context)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, match := range matches {
+		if rel, err := filepath.Rel(b.opts.Context, match); err == nil && rel == "." {
+			files = append(files, context)
+			continue
+		}
+		dir, err := filepath.EvalSymlinks(filepath.Dir(match))
+		if err != nil {
+			return nil, err
+		}
+		if dir != context && !strings.HasPrefix(dir, context+string(filepath.Separator)) {
+			return nil, fmt.Errorf("%s is outside of the build context", match)
+		}
+		files = append(files, filepath.Join(dir, filepath.Base(match)))
+	}
+	return files, nil
+}
+
+// parseChown understands numeric uid[:gid], the passwd file of the image
+// is not consulted.
+func parseChown(chown string) (int, int, error) {
+	if chown == "" {
+		return 0, 0, nil
+	}
+	fields := strings.SplitN(chown, ":", 2)
+	uid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("--chown=%s, only numeric ids are supported", chown)
+	}
+	gid := uid
+	if len(fields) == 2 {
+		if gid, err = strconv.Atoi(fields[1]); err != nil {
+			return 0, 0, fmt.Errorf("--chown=%s, only numeric ids are supported", chown)
+		}
+	}
+	return uid, gid, nil
+}
+
+// isArchive tells whether ADD extracts a file.
+func isArchive(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	r, err := archive.Decompress(f)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	_, err = tar.NewReader(r).Next()
+	return err == nil
+}
+
+// layerWriter writes the layer tar of COPY and hashes what ends up in the
+// image, the modification times aside, for the build cache.
+type layerWriter struct {
+	tw       *tar.Writer
+	hash     hash.Hash
+	uid, gid int
+}
+
+func (w *layerWriter) add(hdr *tar.Header, content io.Reader) error {
+	hdr.Name = strings.TrimPrefix(hdr.Name, "/")
+	fmt.Fprintf(w.hash, "%s\x00%c\x00%o\x00%s\x00%d\x00%d\x00", hdr.Name, hdr.Typeflag, hdr.Mode, hdr.Linkname, hdr.Uid, hdr.Gid)
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if content == nil {
+		return nil
+	}
+	_, err := io.Copy(io.MultiWriter(w.tw, w.hash), content)
+	return err
+}
+
+func (w *layerWriter) addFile(file, name string, fi os.FileInfo) error {
+	link := ""
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		link = target
+	case !fi.IsDir() && !fi.Mode().IsRegular():
+		logrus.Warnf("skip %s, only files, directories and symlinks are copied", file)
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Uid, hdr.Gid = w.uid, w.gid
+	hdr.Uname, hdr.Gname = "", ""
+	if !fi.Mode().IsRegular() {
+		return w.add(hdr, nil)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return w.add(hdr, f)
+}
+
+func (w *layerWriter) addDir(dir, dest string) error {
+	return filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil || name == "." {
+			return err
+		}
+		return w.addFile(file, path.Join(dest, filepath.ToSlash(name)), fi)
+	})
+}
+
+// addArchive extracts a tar into dest, keeping its owners unless --chown
+// says otherwise.
+func (w *layerWriter) addArchive(file, dest string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := archive.Decompress(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// entries cannot leave dest, whiteouts would delete from the image
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" || strings.HasPrefix(path.Base(name), archive.WhiteoutPrefix) {
+			continue
+		}
+		hdr.Name = path.Join(dest, name)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = strings.TrimPrefix(path.Join(dest, path.Clean("/"+hdr.Linkname)), "/")
+		}
+		if w.uid != 0 || w.gid != 0 {
+			hdr.Uid, hdr.Gid = w.uid, w.gid
+		}
+		if err := w.add(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
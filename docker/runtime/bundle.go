@@ -0,0 +1,215 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/cgroups/subsystem"
+	"github.com/go-kinds/docker/container"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// BundleConfig is the name of the OCI runtime config in a bundle.
+const BundleConfig = "config.json"
+
+// LoadBundle reads the OCI runtime config of a bundle.
+func LoadBundle(bundle string) (*specs.Spec, error) {
+	content, err := ioutil.ReadFile(path.Join(bundle, BundleConfig))
+	if err != nil {
+		return nil, fmt.Errorf("read bundle config, err: %v", err)
+	}
+	spec := &specs.Spec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, fmt.Errorf("parse bundle config, err: %v", err)
+	}
+	return spec, nil
+}
+
+// SpecFromBundle maps the OCI runtime config of a bundle onto a container
+// spec. The name is left to the caller, like the container id in runc.
+func SpecFromBundle(bundle string) (*ContainerSpec, error) {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return nil, err
+	}
+	oci, err := LoadBundle(bundle)
+	if err != nil {
+		return nil, err
+	}
+	if oci.Process == nil {
+		return nil, fmt.Errorf("bundle config has no process")
+	}
+	if oci.Root == nil || oci.Root.Path == "" {
+		return nil, fmt.Errorf("bundle config has no root")
+	}
+
+	spec := &ContainerSpec{
+		Cmd:            oci.Process.Args,
+		Env:            oci.Process.Env,
+		Tty:            oci.Process.Terminal,
+		Rootfs:         bundlePath(bundle, oci.Root.Path),
+		RootfsReadonly: oci.Root.Readonly,
+		Bundle:         bundle,
+		Hostname:       oci.Hostname,
+		Cwd:            oci.Process.Cwd,
+		User: &container.User{
+			Uid:            oci.Process.User.UID,
+			Gid:            oci.Process.User.GID,
+			AdditionalGids: oci.Process.User.AdditionalGids,
+		},
+		Resources:   &subsystem.ResourceConfig{},
+		Namespaces:  []string{},
+		Hooks:       oci.Hooks,
+		Annotations: oci.Annotations,
+	}
+	// a bundle lists the paths to protect itself
+	spec.MaskedPaths, spec.ReadonlyPaths = []string{}, []string{}
+	for _, rlimit := range oci.Process.Rlimits {
+		spec.Rlimits = append(spec.Rlimits, container.Rlimit{
+			Type: rlimit.Type,
+			Hard: rlimit.Hard,
+			Soft: rlimit.Soft,
+		})
+	}
+	for _, m := range oci.Mounts {
+		mount := Mount{
+			Source:      m.Source,
+			Destination: m.Destination,
+			Type:        m.Type,
+			Options:     m.Options,
+		}
+		if isBind(m) {
+			mount.Source = bundlePath(bundle, m.Source)
+		}
+		spec.Mounts = append(spec.Mounts, mount)
+	}
+
+	if oci.Linux != nil {
+		for _, ns := range oci.Linux.Namespaces {
+			if ns.Path != "" {
+				return nil, fmt.Errorf("joining the %s namespace at %s is not supported", ns.Type, ns.Path)
+			}
+			spec.Namespaces = append(spec.Namespaces, string(ns.Type))
+		}
+		for _, d := range oci.Linux.Devices {
+			device := container.Device{
+				Path:        d.Path,
+				Type:        d.Type,
+				Major:       d.Major,
+				Minor:       d.Minor,
+				FileMode:    0666,
+				Permissions: "rwm",
+			}
+			// unbuffered character devices are character devices to mknod
+			if device.Type == "u" {
+				device.Type = "c"
+			}
+			if d.FileMode != nil {
+				device.FileMode = *d.FileMode
+			}
+			if d.UID != nil {
+				device.Uid = *d.UID
+			}
+			if d.GID != nil {
+				device.Gid = *d.GID
+			}
+			spec.Devices = append(spec.Devices, device)
+		}
+		if res := oci.Linux.Resources; res != nil {
+			for _, d := range res.Devices {
+				rule := subsystem.DeviceRule{Allow: d.Allow, Type: d.Type, Major: -1, Minor: -1, Access: d.Access}
+				if rule.Type == "" {
+					rule.Type = "a"
+				}
+				if rule.Access == "" {
+					rule.Access = "rwm"
+				}
+				if d.Major != nil {
+					rule.Major = *d.Major
+				}
+				if d.Minor != nil {
+					rule.Minor = *d.Minor
+				}
+				spec.Resources.Devices = append(spec.Resources.Devices, rule)
+			}
+			if res.Memory != nil && res.Memory.Limit != nil {
+				spec.Resources.MemoryLimit = strconv.FormatInt(*res.Memory.Limit, 10)
+			}
+			if res.CPU != nil {
+				if res.CPU.Shares != nil {
+					spec.Resources.CpuShare = strconv.FormatUint(*res.CPU.Shares, 10)
+				}
+				spec.Resources.CpuSet = res.CPU.Cpus
+			}
+		}
+		spec.Sysctls = oci.Linux.Sysctl
+		spec.MaskedPaths = append(spec.MaskedPaths, oci.Linux.MaskedPaths...)
+		spec.ReadonlyPaths = append(spec.ReadonlyPaths, oci.Linux.ReadonlyPaths...)
+		if oci.Linux.CgroupsPath != "" {
+			logrus.Warnf("cgroups path %s is ignored, containers live below the configured cgroup parent", oci.Linux.CgroupsPath)
+		}
+	}
+	return spec, nil
+}
+
+func isBind(m specs.Mount) bool {
+	if m.Type == "bind" {
+		return true
+	}
+	for _, o := range m.Options {
+		if o == "bind" || o == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// bundlePath resolves paths of the bundle config, which are relative to the
+// bundle directory.
+func bundlePath(bundle, p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(bundle, p)
+}
+
+// State reports a container in the format of the OCI runtime spec.
+func (r *Runtime) State(name string) (*specs.State, error) {
+	info, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	state := &specs.State{
+		Version: specs.Version,
+		ID:      info.Name,
+		Status:  info.Status,
+		Bundle:  info.Bundle,
+	}
+	if info.Status != container.STOP {
+		state.Pid, _ = strconv.Atoi(info.Pid)
+	}
+	if info.Bundle != "" {
+		if oci, err := LoadBundle(info.Bundle); err == nil {
+			state.Annotations = oci.Annotations
+		}
+	}
+	return state, nil
+}
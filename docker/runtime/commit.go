@@ -0,0 +1,132 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"github.com/go-kinds/docker/cgroups"
+	"github.com/go-kinds/docker/cgroups/subsystem"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/image"
+	"github.com/go-kinds/docker/storage"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// CommitOptions tune Commit. Changes are Dockerfile instructions applied to
+// the config of the new image, see image.ApplyChange. Pause freezes a
+// running container while its write layer is read.
+type CommitOptions struct {
+	Changes []string
+	Author  string
+	Message string
+	Pause   bool
+}
+
+// Commit stores the write layer of a container as a new layer on top of its
+// image and tags the resulting image with ref, if given. Stopped containers
+// keep their write layer until they are removed, they can be committed too.
+func (r *Runtime) Commit(name, ref string, opts CommitOptions) (*image.Image, error) {
+	info, err := r.imageContainer(name, "committed")
+	if err != nil {
+		return nil, err
+	}
+	diffDir := container.WriteLayerDiff(r.Config, name)
+	driver, err := storage.Get(r.Config.StorageDriver)
+	if err != nil {
+		return nil, err
+	}
+	store := r.ImageStore()
+	base, err := store.Get(info.ImageID)
+	if err != nil {
+		return nil, err
+	}
+
+	config := *base.Config
+	for _, change := range opts.Changes {
+		if err := image.ApplyChange(&config.Config, change); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Pause && info.Status == container.RUNNING && info.IsAlive() {
+		cgroupManager := cgroups.NewCGroupManager(path.Join(r.Config.CgroupParent, info.Id))
+		if err := cgroupManager.Freeze(); err == subsystem.ErrNoFreezer {
+			logrus.Warnf("container %s is committed without pausing it, the host has no freezer", name)
+		} else if err != nil {
+			return nil, fmt.Errorf("pause container %s, err: %v", name, err)
+		} else {
+			defer func() {
+				if err := cgroupManager.Thaw(); err != nil {
+					logrus.Errorf("unpause container %s, err: %v", name, err)
+				}
+			}()
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Diff(diffDir, pw, driver))
+	}()
+	defer pr.Close()
+
+	created := time.Now().UTC()
+	config.Created = &created
+	if opts.Author != "" {
+		config.Author = opts.Author
+	}
+	createdBy := info.Command
+	if len(opts.Changes) > 0 {
+		createdBy += " (" + strings.Join(opts.Changes, ", ") + ")"
+	}
+	config.History = append(append([]v1.History(nil), base.Config.History...), v1.History{
+		Created:   &created,
+		CreatedBy: createdBy,
+		Author:    opts.Author,
+		Comment:   opts.Message,
+	})
+
+	var refs []string
+	if ref != "" {
+		refs = append(refs, ref)
+	}
+	img, err := store.Extend(base, &config, pr, refs...)
+	if err != nil {
+		return nil, fmt.Errorf("commit container %s, err: %v", name, err)
+	}
+	return img, nil
+}
+
+// imageContainer gets a container whose write layer on top of its image is
+// there to be read, what is about to be done to it is told on failure.
+func (r *Runtime) imageContainer(name, action string) (*container.ContainerInfo, error) {
+	info, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.ImageID == "" {
+		return nil, fmt.Errorf("container %s does not run on an image, only image containers can be %s", name, action)
+	}
+	if _, err := os.Stat(container.WriteLayerDiff(r.Config, name)); err != nil {
+		return nil, fmt.Errorf("write layer of container %s, err: %v", name, err)
+	}
+	return info, nil
+}
@@ -0,0 +1,134 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/container"
+	"github.com/sirupsen/logrus"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The shim answers one request per connection on its control socket. A
+// request is a single line naming the action, the reply is a json line.
+const (
+	shimStart = "start"
+	shimWait  = "wait"
+
+	// controlReadTimeout bounds the wait for the request line, a client
+	// which never sends it must not keep the shim from exiting
+	controlReadTimeout = 10 * time.Second
+)
+
+type controlResponse struct {
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (r *Runtime) control(name, action string) (*controlResponse, error) {
+	socketPath := path.Join(container.ContainerDir(r.Config, name), common.ControlSocket)
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connect shim of container %s, err: %v", name, err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, action); err != nil {
+		return nil, err
+	}
+	resp := &controlResponse{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, fmt.Errorf("read shim response, err: %v", err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("%s", resp.Error)
+	}
+	return resp, nil
+}
+
+// controlServer is the shim side of the control socket.
+type controlServer struct {
+	start func() error
+
+	// exited is closed once the container is gone and exitCode is final
+	exited   chan struct{}
+	exitCode int
+
+	// mu guards inflight against exit, no request is added once exiting
+	// is set and exit waits
+	mu       sync.Mutex
+	exiting  bool
+	inflight sync.WaitGroup
+}
+
+func (s *controlServer) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.exiting {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.inflight.Add(1)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *controlServer) handle(conn net.Conn) {
+	defer s.inflight.Done()
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(controlReadTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		logrus.Errorf("read control request, err: %v", err)
+		return
+	}
+	resp := &controlResponse{}
+	switch action := strings.TrimSpace(line); action {
+	case shimStart:
+		if err := s.start(); err != nil {
+			resp.Error = err.Error()
+		}
+	case shimWait:
+		<-s.exited
+		resp.ExitCode = s.exitCode
+	default:
+		resp.Error = fmt.Sprintf("unknown action: %s", action)
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		logrus.Errorf("write control response, err: %v", err)
+	}
+}
+
+// exit releases every waiter and blocks until they got the exit code.
+func (s *controlServer) exit(code int) {
+	s.exitCode = code
+	close(s.exited)
+	s.mu.Lock()
+	s.exiting = true
+	s.mu.Unlock()
+	s.inflight.Wait()
+}
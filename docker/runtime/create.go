@@ -0,0 +1,183 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/container"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// shimConfig is everything the shim needs to set a container up, it is sent
+// to the shim as json.
+type shimConfig struct {
+	Config *common.Config           `json:"config"`
+	Spec   *ContainerSpec           `json:"spec"`
+	Info   *container.ContainerInfo `json:"info"`
+}
+
+// Create sets up a container and its shim. The init process waits in the
+// container until Start hands the user command over to it.
+func (r *Runtime) Create(spec *ContainerSpec) (*container.ContainerInfo, error) {
+	imageID := ""
+	if spec.Rootfs == "" && spec.Image != "" {
+		img, err := r.GetImage(spec.Image)
+		if err != nil {
+			return nil, err
+		}
+		imageID = img.ID
+		spec = spec.withImageConfig(img.Config.Config)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	resources := *spec.Resources
+	resources.Devices = spec.deviceRules()
+	spec.Resources = &resources
+	containerID := container.RandStringBytes(10)
+	containerName := spec.Name
+	if containerName == "" {
+		containerName = containerID
+	}
+	if _, err := container.GetContainerInfo(r.Config, containerName); err == nil {
+		return nil, fmt.Errorf("container name %s is already in use", containerName)
+	}
+	spec, mounts, err := r.resolveMounts(spec, containerName)
+	if err != nil {
+		return nil, err
+	}
+	// containers are named after their id in their uts namespace, HOSTNAME
+	// tells the same
+	if flags, _ := spec.cloneFlags(); spec.Hostname == "" && spec.Bundle == "" && flags&syscall.CLONE_NEWUTS != 0 {
+		spec.Hostname = containerID
+	}
+	cfg := &shimConfig{
+		Config: r.Config,
+		Spec:   spec,
+		Info: &container.ContainerInfo{
+			Id:          containerID,
+			Name:        containerName,
+			Image:       spec.Image,
+			ImageID:     imageID,
+			Command:     strings.Join(spec.Cmd, " "),
+			CreateTime:  now(),
+			Status:      container.CREATED,
+			PortMapping: spec.Ports,
+			Tty:         spec.Tty,
+			Bundle:      spec.Bundle,
+			Mounts:      mounts,
+		},
+	}
+	if err := r.startShim(cfg); err != nil {
+		r.releaseVolumes(containerName, mounts)
+		return nil, err
+	}
+	return container.GetContainerInfo(r.Config, containerName)
+}
+
+// withImageConfig fills in what the spec leaves to the image: the command is
+// the entrypoint followed by Cmd, the environment of the image is extended
+// by the one of the spec, the working directory and the user default to the
+// ones of the image.
+func (s *ContainerSpec) withImageConfig(config v1.ImageConfig) *ContainerSpec {
+	spec := *s
+	entrypoint := config.Entrypoint
+	if spec.Entrypoint != nil {
+		entrypoint = spec.Entrypoint
+	}
+	cmd := spec.Cmd
+	// a new entrypoint does not get the arguments of the old one
+	if len(cmd) == 0 && spec.Entrypoint == nil {
+		cmd = config.Cmd
+	}
+	spec.Entrypoint = nil
+	spec.Cmd = append(append([]string(nil), entrypoint...), cmd...)
+
+	spec.Env = append([]string(nil), config.Env...)
+	for _, kv := range s.Env {
+		spec.Env = setEnv(spec.Env, kv)
+	}
+	if spec.Cwd == "" {
+		spec.Cwd = config.WorkingDir
+	}
+	if spec.User == nil && spec.Username == "" {
+		spec.Username = config.User
+	}
+	return &spec
+}
+
+// setEnv replaces the variable of kv in env or appends kv.
+func setEnv(env []string, kv string) []string {
+	key := strings.SplitN(kv, "=", 2)[0]
+	for i := range env {
+		if strings.SplitN(env[i], "=", 2)[0] == key {
+			env[i] = kv
+			return env
+		}
+	}
+	return append(env, kv)
+}
+
+// startShim forks a shim process which outlives the caller, the shim config
+// is sent over fd 3 and the shim reports the create result back over fd 4.
+func (r *Runtime) startShim(cfg *shimConfig) error {
+	configRead, configWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		configRead.Close()
+		configWrite.Close()
+		return err
+	}
+	defer readyRead.Close()
+
+	cmd := exec.Command(r.Binary, "shim")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.ExtraFiles = []*os.File{configRead, readyWrite}
+	cmd.Dir = "/"
+	if err := cmd.Start(); err != nil {
+		configRead.Close()
+		configWrite.Close()
+		readyWrite.Close()
+		return fmt.Errorf("start shim, err: %v", err)
+	}
+	configRead.Close()
+	readyWrite.Close()
+
+	if err := json.NewEncoder(configWrite).Encode(cfg); err != nil {
+		configWrite.Close()
+		return err
+	}
+	configWrite.Close()
+
+	resp := &controlResponse{}
+	if err := json.NewDecoder(readyRead).Decode(resp); err != nil {
+		_ = cmd.Wait()
+		return fmt.Errorf("shim exited before the container was created, see %s", container.ContainerDir(cfg.Config, cfg.Info.Name))
+	}
+	if resp.Error != "" {
+		_ = cmd.Wait()
+		return fmt.Errorf("%s", resp.Error)
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,135 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/cgroups/subsystem"
+	"github.com/go-kinds/docker/container"
+	"golang.org/x/sys/unix"
+	"os"
+	"path"
+	"strings"
+)
+
+// DefaultDeviceRules deny every device but the ones each container has in
+// /dev, any device node may be created but not opened.
+var DefaultDeviceRules = []subsystem.DeviceRule{
+	{Allow: false, Type: "a", Major: -1, Minor: -1, Access: "rwm"},
+	{Allow: true, Type: "c", Major: -1, Minor: -1, Access: "m"},
+	{Allow: true, Type: "b", Major: -1, Minor: -1, Access: "m"},
+	{Allow: true, Type: "c", Major: 1, Minor: 3, Access: "rwm"},    // /dev/null
+	{Allow: true, Type: "c", Major: 1, Minor: 5, Access: "rwm"},    // /dev/zero
+	{Allow: true, Type: "c", Major: 1, Minor: 7, Access: "rwm"},    // /dev/full
+	{Allow: true, Type: "c", Major: 1, Minor: 8, Access: "rwm"},    // /dev/random
+	{Allow: true, Type: "c", Major: 1, Minor: 9, Access: "rwm"},    // /dev/urandom
+	{Allow: true, Type: "c", Major: 5, Minor: 0, Access: "rwm"},    // /dev/tty
+	{Allow: true, Type: "c", Major: 5, Minor: 1, Access: "rwm"},    // /dev/console
+	{Allow: true, Type: "c", Major: 5, Minor: 2, Access: "rwm"},    // /dev/ptmx
+	{Allow: true, Type: "c", Major: 136, Minor: -1, Access: "rwm"}, // /dev/pts/*
+	{Allow: true, Type: "c", Major: 10, Minor: 200, Access: "rwm"}, // /dev/net/tun
+}
+
+// ParseDevice reads the host[:container][:permissions] form of the --device
+// flag, e.g. /dev/fuse or /dev/sdb:/dev/xvdb:r. The permissions default to
+// rwm.
+func ParseDevice(s string) (container.Device, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return container.Device{}, fmt.Errorf("invalid device %s, should be host[:container][:permissions]", s)
+	}
+	hostPath, containerPath, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 2:
+		if validDevicePermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			containerPath = parts[1]
+		}
+	case 3:
+		containerPath, permissions = parts[1], parts[2]
+	}
+	if !strings.HasPrefix(path.Clean(containerPath), "/dev/") {
+		return container.Device{}, fmt.Errorf("device path %s is not below /dev", containerPath)
+	}
+	if !validDevicePermissions(permissions) {
+		return container.Device{}, fmt.Errorf("invalid device permissions %s, should be made of r, w and m", permissions)
+	}
+	device, err := hostDevice(hostPath)
+	if err != nil {
+		return container.Device{}, err
+	}
+	device.Path = containerPath
+	device.Permissions = permissions
+	return device, nil
+}
+
+// hostDevice describes a device node of the host.
+func hostDevice(hostPath string) (container.Device, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(hostPath, &stat); err != nil {
+		return container.Device{}, fmt.Errorf("stat device %s, err: %v", hostPath, err)
+	}
+	device := container.Device{
+		Path:     hostPath,
+		Major:    int64(unix.Major(uint64(stat.Rdev))),
+		Minor:    int64(unix.Minor(uint64(stat.Rdev))),
+		FileMode: os.FileMode(stat.Mode & 0777),
+		Uid:      stat.Uid,
+		Gid:      stat.Gid,
+	}
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		device.Type = "c"
+	case unix.S_IFBLK:
+		device.Type = "b"
+	default:
+		return container.Device{}, fmt.Errorf("%s is not a device", hostPath)
+	}
+	return device, nil
+}
+
+func validDevicePermissions(permissions string) bool {
+	if permissions == "" {
+		return false
+	}
+	for _, c := range permissions {
+		if !strings.ContainsRune("rwm", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// deviceRules are the rules of the devices cgroup: the default policy
+// unless the spec is of a bundle, which brings its own, the rules of the
+// spec and access to its devices.
+func (s *ContainerSpec) deviceRules() []subsystem.DeviceRule {
+	var rules []subsystem.DeviceRule
+	if s.Bundle == "" {
+		rules = append(rules, DefaultDeviceRules...)
+	}
+	rules = append(rules, s.Resources.Devices...)
+	for _, device := range s.Devices {
+		rules = append(rules, subsystem.DeviceRule{
+			Allow:  true,
+			Type:   device.Type,
+			Major:  device.Major,
+			Minor:  device.Minor,
+			Access: device.Permissions,
+		})
+	}
+	return rules
+}
@@ -0,0 +1,128 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-kinds/docker/container"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseEnv reads the KEY=VALUE form of the -e flag. A bare KEY takes the
+// value lookup finds, usually in the environment of the caller, and is
+// left out when there is none.
+func ParseEnv(s string, lookup func(key string) (string, bool)) (string, bool, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if kv[0] == "" || strings.ContainsAny(kv[0], " \t") {
+		return "", false, fmt.Errorf("invalid environment variable %s", s)
+	}
+	if len(kv) == 2 {
+		return s, true, nil
+	}
+	value, ok := lookup(kv[0])
+	if !ok {
+		return "", false, nil
+	}
+	return kv[0] + "=" + value, true, nil
+}
+
+// ReadEnvFile reads the variables of an --env-file, one KEY=VALUE or bare
+// KEY as for ParseEnv per line. Blank lines and lines starting with # are
+// skipped. A value in double quotes may use \", \\ and \n, one in single
+// quotes is taken as it is.
+func ReadEnvFile(filePath string, lookup func(key string) (string, bool)) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		if i := strings.Index(line, "="); i > 0 {
+			value, err := unquoteEnvValue(line[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d, err: %v", filePath, n, err)
+			}
+			line = strings.TrimSpace(line[:i]) + "=" + value
+		}
+		kv, ok, err := ParseEnv(line, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d, err: %v", filePath, n, err)
+		}
+		if ok {
+			env = append(env, kv)
+		}
+	}
+	return env, scanner.Err()
+}
+
+func unquoteEnvValue(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 || (value[0] != '"' && value[0] != '\'') {
+		return value, nil
+	}
+	if len(value) < 2 || value[len(value)-1] != value[0] {
+		return "", fmt.Errorf("unterminated quote in %s", value)
+	}
+	if value[0] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted value %s", value)
+	}
+	return unquoted, nil
+}
+
+// processEnv completes the environment of the container process. Every
+// process gets a HOME, from the passwd file of rootfs, containers not from
+// a bundle a PATH and their HOSTNAME as well.
+func (s *ContainerSpec) processEnv(rootfs string) []string {
+	env := append([]string{}, s.Env...)
+	if s.Bundle == "" {
+		if !hasEnv(env, "PATH") {
+			env = append(env, "PATH="+DefaultPath)
+		}
+		if s.Hostname != "" && !hasEnv(env, "HOSTNAME") {
+			env = append(env, "HOSTNAME="+s.Hostname)
+		}
+	}
+	if !hasEnv(env, "HOME") {
+		user := s.Username
+		if s.User != nil {
+			user = strconv.FormatUint(uint64(s.User.Uid), 10)
+		}
+		env = append(env, "HOME="+container.LookupHome(rootfs, user))
+	}
+	return env
+}
+
+func hasEnv(env []string, key string) bool {
+	for _, kv := range env {
+		if strings.SplitN(kv, "=", 2)[0] == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lookupIn(env map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	lookup := lookupIn(map[string]string{"HOME": "/root", "EMPTY": ""})
+	tests := []struct {
+		s       string
+		want    string
+		ok      bool
+		wantErr bool
+	}{
+		{s: "A=1", want: "A=1", ok: true},
+		{s: "A=", want: "A=", ok: true},
+		{s: "A=b=c", want: "A=b=c", ok: true},
+		{s: "HOME", want: "HOME=/root", ok: true},
+		{s: "EMPTY", want: "EMPTY=", ok: true},
+		{s: "UNSET"},
+		{s: "=1", wantErr: true},
+		{s: "A B=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, ok, err := ParseEnv(tt.s, lookup)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseEnv(%s) = %q, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want || ok != tt.ok {
+			t.Errorf("ParseEnv(%s) = %q, %v, %v, want %q, %v", tt.s, got, ok, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	lookup := lookupIn(map[string]string{"HOME": "/root"})
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "plain",
+			content: "# comment\n\nA=1\n  B = 2 \nexport C=3\nHOME\nUNSET\n",
+			want:    []string{"A=1", "B=2", "C=3", "HOME=/root"},
+		},
+		{
+			name:    "quoted",
+			content: `A="a \"b\"\nc"` + "\n" + `B='$x \n'` + "\n" + `C=""` + "\n",
+			want:    []string{"A=a \"b\"\nc", `B=$x \n`, "C="},
+		},
+		{
+			name:    "unterminated quote",
+			content: "A=1\nB=\"open\n",
+			wantErr: "line 2",
+		},
+		{
+			name:    "invalid key",
+			content: "A B=1\n",
+			wantErr: "invalid environment variable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := path.Join(t.TempDir(), "env")
+			if err := ioutil.WriteFile(file, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadEnvFile(file, lookup)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %q, err %v, want %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadEnvFileMissing(t *testing.T) {
+	if _, err := ReadEnvFile(path.Join(t.TempDir(), "missing"), lookupIn(nil)); err == nil {
+		t.Fatalf("a missing file is read")
+	}
+}
@@ -0,0 +1,73 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-kinds/docker/container"
+	"io"
+	"io/ioutil"
+	"os/exec"
+)
+
+// ExecSpec describes a process to run inside a running container.
+type ExecSpec struct {
+	Cmd []string
+	// Env is added to the environment of the container init process
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Exec runs a process in the namespaces of a running container and returns
+// its exit code once it is done.
+func (r *Runtime) Exec(name string, spec *ExecSpec) (int, error) {
+	if len(spec.Cmd) == 0 {
+		return -1, fmt.Errorf("missing exec command")
+	}
+	info, err := r.Get(name)
+	if err != nil {
+		return -1, err
+	}
+	if info.Status != container.RUNNING || info.Pid == "" {
+		return -1, fmt.Errorf("container %s is not running", name)
+	}
+
+	environ, err := ioutil.ReadFile(fmt.Sprintf("/proc/%s/environ", info.Pid))
+	if err != nil {
+		return -1, fmt.Errorf("read environ of container %s, err: %v", name, err)
+	}
+	var env []string
+	for _, kv := range bytes.Split(environ, []byte{0}) {
+		if len(kv) > 0 {
+			env = append(env, string(kv))
+		}
+	}
+
+	args := append([]string{"--target", info.Pid, "--root", "--wd", "--mount", "--uts", "--ipc", "--net", "--pid", "--"}, spec.Cmd...)
+	cmd := exec.Command("nsenter", args...)
+	cmd.Env = append(env, spec.Env...)
+	cmd.Stdin = spec.Stdin
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return -1, fmt.Errorf("exec in container %s, err: %v", name, err)
+		}
+	}
+	return exitCode(cmd.ProcessState), nil
+}
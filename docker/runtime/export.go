@@ -0,0 +1,100 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/storage"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Export writes the root file system of a container as the container sees
+// it, its image with its changes on top, as a flat tar. Mounts live in the
+// mount namespace of the container, their content is left out.
+func (r *Runtime) Export(name string, w io.Writer) error {
+	info, err := r.imageContainer(name, "exported")
+	if err != nil {
+		return err
+	}
+	rootfs := path.Join(r.Config.MntPath(), name)
+	// the merged view is mounted while the container runs only, a stopped
+	// container gets it mounted for the time of the export
+	if info.Status == container.STOP || !info.IsAlive() {
+		layerDirs, err := r.imageLayerDirs(info.ImageID)
+		if err != nil {
+			return err
+		}
+		if rootfs, err = ioutil.TempDir("", "go-docker-export-"); err != nil {
+			return err
+		}
+		defer os.Remove(rootfs)
+		unmount, err := container.MountWriteLayer(r.Config, name, layerDirs, rootfs)
+		if err != nil {
+			return fmt.Errorf("mount container %s, err: %v", name, err)
+		}
+		defer func() {
+			if err := unmount(); err != nil {
+				logrus.Errorf("unmount container %s, err: %v", name, err)
+			}
+		}()
+	}
+	if err := archive.Tar(rootfs, w); err != nil {
+		return fmt.Errorf("export container %s, err: %v", name, err)
+	}
+	return nil
+}
+
+// imageLayerDirs lists the layer directories of an image, the top one
+// first.
+func (r *Runtime) imageLayerDirs(imageID string) ([]string, error) {
+	store := r.ImageStore()
+	img, err := store.Get(imageID)
+	if err != nil {
+		return nil, err
+	}
+	var layerDirs []string
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+		layerDirs = append(layerDirs, store.LayerDir(img.Layers[i]))
+	}
+	return layerDirs, nil
+}
+
+// Diff lists what a container added, changed and deleted on top of its
+// image.
+func (r *Runtime) Diff(name string) ([]archive.Change, error) {
+	info, err := r.imageContainer(name, "compared")
+	if err != nil {
+		return nil, err
+	}
+	driver, err := storage.Get(r.Config.StorageDriver)
+	if err != nil {
+		return nil, err
+	}
+	layerDirs, err := r.imageLayerDirs(info.ImageID)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := archive.Changes(container.WriteLayerDiff(r.Config, name), layerDirs, driver)
+	if err != nil {
+		return nil, fmt.Errorf("compare container %s with its image, err: %v", name, err)
+	}
+	return changes, nil
+}
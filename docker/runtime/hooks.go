@@ -0,0 +1,198 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/container"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The hook stages, named like in the OCI runtime config. All of them run in
+// the runtime namespaces, with the OCI state of the container on stdin.
+const (
+	// HookPrestart and HookCreateRuntime run once the container is set up,
+	// before its command is started. A failing hook aborts the creation.
+	HookPrestart      = "prestart"
+	HookCreateRuntime = "createRuntime"
+	// HookPoststart runs after the command is started, failures are logged.
+	HookPoststart = "poststart"
+	// HookPoststop runs once the container is torn down, failures are logged.
+	HookPoststop = "poststop"
+)
+
+// hookKillWait bounds the wait for a hook killed after its timeout.
+const hookKillWait = 2 * time.Second
+
+// AddHook appends a hook to a stage.
+func (s *ContainerSpec) AddHook(stage string, hook specs.Hook) error {
+	if s.Hooks == nil {
+		s.Hooks = &specs.Hooks{}
+	}
+	switch stage {
+	case HookPrestart:
+		s.Hooks.Prestart = append(s.Hooks.Prestart, hook)
+	case HookCreateRuntime:
+		s.Hooks.CreateRuntime = append(s.Hooks.CreateRuntime, hook)
+	case HookPoststart:
+		s.Hooks.Poststart = append(s.Hooks.Poststart, hook)
+	case HookPoststop:
+		s.Hooks.Poststop = append(s.Hooks.Poststop, hook)
+	default:
+		return fmt.Errorf("unknown hook stage: %s", stage)
+	}
+	return nil
+}
+
+func (s *ContainerSpec) hooks(stage string) []specs.Hook {
+	if s.Hooks == nil {
+		return nil
+	}
+	switch stage {
+	case HookPrestart:
+		return s.Hooks.Prestart
+	case HookCreateRuntime:
+		return s.Hooks.CreateRuntime
+	case HookPoststart:
+		return s.Hooks.Poststart
+	case HookPoststop:
+		return s.Hooks.Poststop
+	}
+	return nil
+}
+
+func validateHooks(hooks *specs.Hooks) error {
+	if hooks == nil {
+		return nil
+	}
+	// these would have to run inside the container namespaces
+	if len(hooks.CreateContainer) > 0 || len(hooks.StartContainer) > 0 {
+		return fmt.Errorf("createContainer and startContainer hooks are not supported")
+	}
+	for _, stage := range [][]specs.Hook{hooks.Prestart, hooks.CreateRuntime, hooks.Poststart, hooks.Poststop} {
+		for _, hook := range stage {
+			if !path.IsAbs(hook.Path) {
+				return fmt.Errorf("hook path %s is not absolute", hook.Path)
+			}
+			if hook.Timeout != nil && *hook.Timeout <= 0 {
+				return fmt.Errorf("timeout of hook %s must be positive", hook.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseHook reads the stage=command form of the --hook flag, the command is
+// split at spaces. A positive timeout is applied to the hook.
+func ParseHook(s string, timeout int) (string, specs.Hook, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || len(strings.Fields(parts[1])) == 0 {
+		return "", specs.Hook{}, fmt.Errorf("invalid hook: %s, should be stage=command", s)
+	}
+	args := strings.Fields(parts[1])
+	hook := specs.Hook{Path: args[0], Args: args}
+	if timeout > 0 {
+		hook.Timeout = &timeout
+	}
+	return parts[0], hook, nil
+}
+
+// runHooks runs the hooks of a stage in order and stops at the first one
+// which fails.
+func runHooks(stage string, hooks []specs.Hook, state *specs.State) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if err := runHook(hook, content); err != nil {
+			return fmt.Errorf("%s hook %s, err: %v", stage, hook.Path, err)
+		}
+	}
+	return nil
+}
+
+func runHook(hook specs.Hook, state []byte) error {
+	var output bytes.Buffer
+	cmd := &exec.Cmd{
+		Path:   hook.Path,
+		Args:   hook.Args,
+		Env:    hook.Env,
+		Stdin:  bytes.NewReader(state),
+		Stdout: &output,
+		Stderr: &output,
+		// the hook leads a process group, a timeout kills what it started
+		// as well
+		SysProcAttr: &syscall.SysProcAttr{Setpgid: true},
+	}
+	if len(cmd.Args) == 0 {
+		cmd.Args = []string{hook.Path}
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var timeout <-chan time.Time
+	if hook.Timeout != nil {
+		timer := time.NewTimer(time.Duration(*hook.Timeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err := <-done:
+		if err != nil && output.Len() > 0 {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(output.String()))
+		}
+		return err
+	case <-timeout:
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		// a process which left the group may still hold the output open
+		select {
+		case <-done:
+		case <-time.After(hookKillWait):
+		}
+		return fmt.Errorf("timed out after %ds", *hook.Timeout)
+	}
+}
+
+// hookState is the OCI state handed to the hooks of a container.
+func hookState(cfg *shimConfig, status string) *specs.State {
+	state := &specs.State{
+		Version:     specs.Version,
+		ID:          cfg.Info.Name,
+		Status:      status,
+		Bundle:      cfg.Info.Bundle,
+		Annotations: cfg.Spec.Annotations,
+	}
+	if status != container.STOP {
+		state.Pid, _ = strconv.Atoi(cfg.Info.Pid)
+	}
+	return state
+}
@@ -0,0 +1,131 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/image"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ImageStore is the local image store of the runtime.
+func (r *Runtime) ImageStore() *image.Store {
+	return image.NewStore(r.Config)
+}
+
+func (r *Runtime) ListImages() ([]*image.Image, error) {
+	return r.ImageStore().List()
+}
+
+// GetImage looks an image up in the store. A flat rootfs tar <root>/<ref>.tar
+// from before the store existed is imported on first use.
+func (r *Runtime) GetImage(ref string) (*image.Image, error) {
+	store := r.ImageStore()
+	img, err := store.Get(ref)
+	if err == nil {
+		return img, nil
+	}
+	if strings.ContainsAny(ref, "/:@") {
+		return nil, err
+	}
+	legacyTar := path.Join(r.Config.Root, ref+".tar")
+	if _, statErr := os.Stat(legacyTar); statErr != nil {
+		return nil, err
+	}
+	logrus.Infof("import %s into the image store", legacyTar)
+	return store.ImportRootfs(legacyTar, ref)
+}
+
+// LoadImages imports a docker save archive or an OCI image layout tar.
+func (r *Runtime) LoadImages(archive io.Reader) ([]image.LoadResult, error) {
+	return r.ImageStore().Load(archive)
+}
+
+// ImportOptions tune ImportImage. Changes are Dockerfile instructions
+// applied to the config of the image, see image.ApplyChange.
+type ImportOptions struct {
+	Changes []string
+	Message string
+}
+
+// ImportImage stores a flat root file system tar, such as Export writes, as
+// a single layer image. source names the tar in the history of the image.
+func (r *Runtime) ImportImage(tarStream io.Reader, source, ref string, opts ImportOptions) (*image.Image, error) {
+	created := time.Now().UTC()
+	config := &v1.Image{
+		Created: &created,
+		History: []v1.History{{Created: &created, CreatedBy: "import " + source, Comment: opts.Message}},
+	}
+	for _, change := range opts.Changes {
+		if err := image.ApplyChange(&config.Config, change); err != nil {
+			return nil, err
+		}
+	}
+	var refs []string
+	if ref != "" {
+		refs = append(refs, ref)
+	}
+	return r.ImageStore().Import(tarStream, config, refs...)
+}
+
+func (r *Runtime) TagImage(ref, target string) error {
+	return r.ImageStore().Tag(ref, target)
+}
+
+// RemoveImage untags or deletes an image, see image.Store.Remove. Images of
+// live containers are never deleted, those of stopped ones only with force.
+func (r *Runtime) RemoveImage(ref string, force bool) ([]string, error) {
+	store := r.ImageStore()
+	img, err := store.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	// untagging one of several tags leaves the image in place
+	untagOnly := !force && len(img.RepoTags) > 1 && contains(img.RepoTags, image.NormalizeReference(ref))
+	if !untagOnly {
+		for _, info := range infos {
+			if info.ImageID != img.ID {
+				continue
+			}
+			if info.Status != container.STOP && info.IsAlive() {
+				return nil, fmt.Errorf("image %s is used by container %s which is %s", ref, info.Name, info.Status)
+			}
+			if !force {
+				return nil, fmt.Errorf("image %s is used by stopped container %s, remove it first or force the removal", ref, info.Name)
+			}
+		}
+	}
+	return store.Remove(ref, force)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
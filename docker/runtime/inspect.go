@@ -0,0 +1,265 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/cgroups/subsystem"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/image"
+	"github.com/go-kinds/docker/network"
+	"io/ioutil"
+	"path"
+	"strconv"
+)
+
+// specFile keeps the spec a container was created with, complete with what
+// its image filled in, next to its record.
+const specFile = "spec.json"
+
+func writeSpec(conf *common.Config, containerName string, spec *ContainerSpec) error {
+	content, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(container.ContainerDir(conf, containerName), specFile), content, 0644)
+}
+
+func readSpec(conf *common.Config, containerName string) (*ContainerSpec, error) {
+	content, err := ioutil.ReadFile(path.Join(container.ContainerDir(conf, containerName), specFile))
+	if err != nil {
+		return nil, err
+	}
+	spec := &ContainerSpec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// ContainerDetails is what inspect shows of a container. Config is nil for
+// containers created before their spec was kept.
+type ContainerDetails struct {
+	ID              string                 `json:"id"`
+	Name            string                 `json:"name"`
+	Created         string                 `json:"created"`
+	Image           string                 `json:"image"`
+	ImageID         string                 `json:"image_id,omitempty"`
+	Command         string                 `json:"command"`
+	State           ContainerState         `json:"state"`
+	Config          *ContainerSpec         `json:"config"`
+	Mounts          []container.MountPoint `json:"mounts"`
+	NetworkSettings NetworkSettings        `json:"network_settings"`
+	// CgroupPath is relative to the root of every cgroup hierarchy, Cgroups
+	// are the directories of the subsystems the container has
+	CgroupPath string            `json:"cgroup_path"`
+	Cgroups    map[string]string `json:"cgroups,omitempty"`
+	Storage    StorageDetails    `json:"storage"`
+}
+
+type ContainerState struct {
+	Status     string `json:"status"`
+	Running    bool   `json:"running"`
+	Pid        int    `json:"pid"`
+	MonitorPid int    `json:"monitor_pid"`
+	ExitCode   int    `json:"exit_code"`
+}
+
+// NetworkSettings tell how a container is reached, Ports are the host port
+// container port pairs.
+type NetworkSettings struct {
+	Network    string   `json:"network,omitempty"`
+	IPAddress  string   `json:"ip_address,omitempty"`
+	MacAddress string   `json:"mac_address,omitempty"`
+	Gateway    string   `json:"gateway,omitempty"`
+	Subnet     string   `json:"subnet,omitempty"`
+	Ports      []string `json:"ports,omitempty"`
+}
+
+// StorageDetails locate the root file system of a container: Rootfs is
+// what the container pivots into, a work space of UpperDir on top of
+// LowerDirs, the top one first, unless the container runs on a directory.
+type StorageDetails struct {
+	Driver    string   `json:"driver,omitempty"`
+	Rootfs    string   `json:"rootfs"`
+	UpperDir  string   `json:"upper_dir,omitempty"`
+	LowerDirs []string `json:"lower_dirs,omitempty"`
+}
+
+// InspectContainer gathers the record, the spec, the network, the cgroups
+// and the storage of a container.
+func (r *Runtime) InspectContainer(name string) (*ContainerDetails, error) {
+	info, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	details := &ContainerDetails{
+		ID:      info.Id,
+		Name:    info.Name,
+		Created: info.CreateTime,
+		Image:   info.Image,
+		ImageID: info.ImageID,
+		Command: info.Command,
+		State: ContainerState{
+			Status:   info.Status,
+			Running:  info.Status == container.RUNNING && info.IsAlive(),
+			ExitCode: info.ExitCode,
+		},
+		Mounts: info.Mounts,
+		NetworkSettings: NetworkSettings{
+			Network:    info.Network,
+			IPAddress:  info.IPAddress,
+			MacAddress: info.MacAddress,
+			Ports:      info.PortMapping,
+		},
+		CgroupPath: path.Join(r.Config.CgroupParent, info.Id),
+	}
+	details.State.Pid, _ = strconv.Atoi(info.Pid)
+	details.State.MonitorPid, _ = strconv.Atoi(info.MonitorPid)
+	if details.Mounts == nil {
+		details.Mounts = []container.MountPoint{}
+	}
+	if spec, err := readSpec(r.Config, info.Name); err == nil {
+		details.Config = spec
+	}
+	if info.Network != "" {
+		if nw, err := network.Get(r.Config, info.Network); err == nil && nw.IpRange != nil {
+			details.NetworkSettings.Gateway = nw.IpRange.IP.String()
+			subnet := *nw.IpRange
+			subnet.IP = subnet.IP.Mask(subnet.Mask)
+			details.NetworkSettings.Subnet = subnet.String()
+		}
+	}
+	for _, sub := range subsystem.Subsystems {
+		if dir, err := subsystem.GetCgroupPath(sub.Name(), details.CgroupPath, false); err == nil {
+			if details.Cgroups == nil {
+				details.Cgroups = map[string]string{}
+			}
+			details.Cgroups[sub.Name()] = dir
+		}
+	}
+	details.Storage = r.containerStorage(info, details.Config)
+	return details, nil
+}
+
+func (r *Runtime) containerStorage(info *container.ContainerInfo, spec *ContainerSpec) StorageDetails {
+	storage := StorageDetails{Rootfs: path.Join(r.Config.MntPath(), info.Name)}
+	switch {
+	case spec != nil && spec.Rootfs != "" && !spec.RootfsLower:
+		storage.Rootfs = spec.Rootfs
+		return storage
+	case spec != nil && spec.Rootfs != "":
+		storage.LowerDirs = []string{spec.Rootfs}
+	case info.ImageID != "":
+		store := r.ImageStore()
+		if img, err := store.Get(info.ImageID); err == nil {
+			for i := len(img.Layers) - 1; i >= 0; i-- {
+				storage.LowerDirs = append(storage.LowerDirs, store.LayerDir(img.Layers[i]))
+			}
+		}
+	}
+	storage.Driver = r.Config.StorageDriver
+	storage.UpperDir = container.WriteLayerDiff(r.Config, info.Name)
+	return storage
+}
+
+// NetworkDetails is what inspect shows of a network, Containers are the
+// containers connected to it by name.
+type NetworkDetails struct {
+	Name       string                     `json:"name"`
+	Driver     string                     `json:"driver"`
+	Subnet     string                     `json:"subnet"`
+	Gateway    string                     `json:"gateway"`
+	Containers map[string]NetworkEndpoint `json:"containers"`
+}
+
+type NetworkEndpoint struct {
+	ID         string   `json:"id"`
+	IPAddress  string   `json:"ip_address"`
+	MacAddress string   `json:"mac_address,omitempty"`
+	Ports      []string `json:"ports,omitempty"`
+}
+
+func (r *Runtime) InspectNetwork(name string) (*NetworkDetails, error) {
+	nw, err := network.Get(r.Config, name)
+	if err != nil {
+		return nil, err
+	}
+	if nw.IpRange == nil {
+		return nil, fmt.Errorf("network %s has no ip range", name)
+	}
+	subnet := *nw.IpRange
+	subnet.IP = subnet.IP.Mask(subnet.Mask)
+	details := &NetworkDetails{
+		Name:       nw.Name,
+		Driver:     nw.Driver,
+		Subnet:     subnet.String(),
+		Gateway:    nw.IpRange.IP.String(),
+		Containers: map[string]NetworkEndpoint{},
+	}
+	infos, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Network != nw.Name || !info.IsAlive() {
+			continue
+		}
+		details.Containers[info.Name] = NetworkEndpoint{
+			ID:         info.Id,
+			IPAddress:  info.IPAddress,
+			MacAddress: info.MacAddress,
+			Ports:      info.PortMapping,
+		}
+	}
+	return details, nil
+}
+
+// Inspect finds name among the objects of the kinds asked for, containers,
+// images, volumes and networks when kind is empty.
+func (r *Runtime) Inspect(kind, name string) (interface{}, error) {
+	kinds := []string{kind}
+	if kind == "" {
+		kinds = []string{"container", "image", "volume", "network"}
+	}
+	for _, k := range kinds {
+		var object interface{}
+		var err error
+		switch k {
+		case "container":
+			object, err = r.InspectContainer(name)
+		case "image":
+			// the store only, GetImage would import a legacy rootfs tar
+			var img *image.Image
+			img, err = r.ImageStore().Get(name)
+			object = img
+		case "volume":
+			object, err = r.GetVolume(name)
+		case "network":
+			object, err = r.InspectNetwork(name)
+		default:
+			return nil, fmt.Errorf("unknown type %s, use container, image, network or volume", k)
+		}
+		if err == nil {
+			return object, nil
+		}
+		if kind != "" {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("no such object: %s", name)
+}
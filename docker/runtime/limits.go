@@ -0,0 +1,110 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/container"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ParseUlimit reads the name=soft[:hard] form of the --ulimit flag, e.g.
+// nofile=65535:65535. The hard limit defaults to the soft one, unlimited or
+// -1 means no limit.
+func ParseUlimit(s string) (container.Rlimit, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return container.Rlimit{}, fmt.Errorf("invalid ulimit %s, should be name=soft[:hard]", s)
+	}
+	rlimit := container.Rlimit{Type: "RLIMIT_" + strings.ToUpper(kv[0])}
+	values := strings.SplitN(kv[1], ":", 2)
+	var err error
+	if rlimit.Soft, err = parseLimit(values[0]); err != nil {
+		return container.Rlimit{}, fmt.Errorf("invalid ulimit %s, err: %v", s, err)
+	}
+	rlimit.Hard = rlimit.Soft
+	if len(values) == 2 {
+		if rlimit.Hard, err = parseLimit(values[1]); err != nil {
+			return container.Rlimit{}, fmt.Errorf("invalid ulimit %s, err: %v", s, err)
+		}
+	}
+	if err := container.ValidateRlimit(rlimit); err != nil {
+		return container.Rlimit{}, err
+	}
+	return rlimit, nil
+}
+
+func parseLimit(s string) (uint64, error) {
+	if s == "unlimited" || s == "-1" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// ParseSysctl reads the key=value form of the --sysctl flag.
+func ParseSysctl(s string) (string, string, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return "", "", fmt.Errorf("invalid sysctl %s, should be key=value", s)
+	}
+	return kv[0], kv[1], nil
+}
+
+// ipcSysctls belong to the ipc namespace, as does everything below
+// fs.mqueue.
+var ipcSysctls = []string{
+	"kernel.msgmax",
+	"kernel.msgmnb",
+	"kernel.msgmni",
+	"kernel.sem",
+	"kernel.shmall",
+	"kernel.shmmax",
+	"kernel.shmmni",
+	"kernel.shm_rmid_forced",
+}
+
+// sysctlKey is a dotted sysctl name, the components name the directories
+// below /proc/sys, interface names of net.* included.
+var sysctlKey = regexp.MustCompile(`^[a-z0-9_]+(\.[a-zA-Z0-9_-]+)+$`)
+
+// validateSysctl accepts only sysctls of a namespace the container has, any
+// other one would change the host.
+func validateSysctl(key string, flags uintptr) error {
+	if !sysctlKey.MatchString(key) {
+		return fmt.Errorf("invalid sysctl %s", key)
+	}
+	var ns uintptr
+	name := ""
+	switch {
+	case contains(ipcSysctls, key) || strings.HasPrefix(key, "fs.mqueue."):
+		ns, name = syscall.CLONE_NEWIPC, "ipc"
+	case strings.HasPrefix(key, "net."):
+		ns, name = syscall.CLONE_NEWNET, "network"
+	case key == "kernel.hostname":
+		return fmt.Errorf("sysctl %s is set through the hostname of the container", key)
+	case key == "kernel.domainname":
+		ns, name = syscall.CLONE_NEWUTS, "uts"
+	default:
+		return fmt.Errorf("sysctl %s is not namespaced, it can't be set for a container", key)
+	}
+	if flags&ns == 0 {
+		return fmt.Errorf("sysctl %s needs a %s namespace", key, name)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/container"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+var propagations = []string{"private", "rprivate", "shared", "rshared", "slave", "rslave"}
+
+// tmpfsOptions are the defaults of tmpfs mounts, given options come after
+// them and win.
+var tmpfsOptions = []string{"nosuid", "nodev", "noexec"}
+
+// ParseVolume reads the src:dst[:options] form of the -v flag. An absolute
+// src is bind mounted, any other src names a volume. The options are ro or
+// rw and a propagation, comma separated.
+func ParseVolume(s string) (Mount, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Mount{}, fmt.Errorf("invalid volume: %s, should be src:dst[:ro|rw][,propagation]", s)
+	}
+	m := Mount{Source: parts[0], Destination: parts[1], Type: MountTypeBind, Options: []string{"rbind"}}
+	if !path.IsAbs(m.Source) {
+		m.Type = MountTypeVolume
+	}
+	if len(parts) == 3 {
+		for _, option := range strings.Split(parts[2], ",") {
+			switch {
+			case option == "ro" || option == "rw":
+				m.Options = append(m.Options, option)
+			case contains(propagations, option) && m.Type == MountTypeBind:
+				m.Options = append(m.Options, option)
+			default:
+				return Mount{}, fmt.Errorf("invalid volume option %s in %s", option, s)
+			}
+		}
+	}
+	return m, nil
+}
+
+// ParseTmpfs reads the dst[:options] form of the --tmpfs flag. Options like
+// size=64m,mode=1777 go to tmpfs.
+func ParseTmpfs(s string) (Mount, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if parts[0] == "" {
+		return Mount{}, fmt.Errorf("invalid tmpfs: %s, should be dst[:options]", s)
+	}
+	m := Mount{Source: "tmpfs", Destination: parts[0], Type: MountTypeTmpfs}
+	m.Options = append(m.Options, tmpfsOptions...)
+	if len(parts) == 2 && parts[1] != "" {
+		m.Options = append(m.Options, strings.Split(parts[1], ",")...)
+	}
+	return m, nil
+}
+
+// ParseMount reads the key=value,... form of the --mount flag. The keys are
+// type (bind, volume or tmpfs, volume by default), src, dst, readonly,
+// bind-propagation, tmpfs-size and tmpfs-mode.
+func ParseMount(s string) (Mount, error) {
+	m := Mount{Type: MountTypeVolume}
+	var options []string
+	for _, field := range strings.Split(s, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		key, value := strings.TrimSpace(kv[0]), ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		switch key {
+		case "type":
+			m.Type = value
+		case "source", "src":
+			m.Source = value
+		case "destination", "dst", "target":
+			m.Destination = value
+		case "readonly", "ro":
+			readonly := true
+			if value != "" {
+				var err error
+				if readonly, err = strconv.ParseBool(value); err != nil {
+					return Mount{}, fmt.Errorf("invalid %s=%s in mount %s", key, value, s)
+				}
+			}
+			if readonly {
+				options = append(options, "ro")
+			}
+		case "bind-propagation":
+			if !contains(propagations, value) {
+				return Mount{}, fmt.Errorf("invalid bind-propagation %s, should be one of %s", value, strings.Join(propagations, ", "))
+			}
+			options = append(options, value)
+		case "tmpfs-size":
+			options = append(options, "size="+value)
+		case "tmpfs-mode":
+			options = append(options, "mode="+value)
+		default:
+			return Mount{}, fmt.Errorf("unsupported key %s in mount %s", key, s)
+		}
+	}
+	if m.Destination == "" {
+		return Mount{}, fmt.Errorf("mount %s needs a dst", s)
+	}
+	switch m.Type {
+	case MountTypeBind, MountTypeVolume:
+		if m.Source == "" {
+			return Mount{}, fmt.Errorf("mount %s needs a src", s)
+		}
+		m.Options = append([]string{"rbind"}, options...)
+	case MountTypeTmpfs:
+		if m.Source != "" {
+			return Mount{}, fmt.Errorf("tmpfs mount %s takes no src", s)
+		}
+		m.Source = "tmpfs"
+		m.Options = append(append([]string(nil), tmpfsOptions...), options...)
+	default:
+		return Mount{}, fmt.Errorf("unsupported mount type %s, use bind, volume or tmpfs", m.Type)
+	}
+	return m, nil
+}
+
+// resolveMounts turns volumes into binds of their directory, creating
+// volumes on first use, and lists the mounts for the record. The volumes are
+// used by containerName from now on, see releaseVolumes. Mounts of a bundle
+// keep their order, the others are sorted so that a mount comes after the
+// ones it is mounted into.
+func (r *Runtime) resolveMounts(spec *ContainerSpec, containerName string) (*ContainerSpec, []container.MountPoint, error) {
+	resolved := *spec
+	resolved.Mounts = nil
+	var points []container.MountPoint
+	for _, m := range spec.Mounts {
+		name := ""
+		if m.Type == MountTypeVolume {
+			v, err := r.VolumeStore().Use(m.Source, containerName)
+			if err != nil {
+				r.releaseVolumes(containerName, points)
+				return nil, nil, err
+			}
+			name = v.Name
+			m.Source = v.Mountpoint
+			m.Type = MountTypeBind
+		} else if m.Type == MountTypeBind {
+			if _, err := os.Stat(m.Source); err != nil {
+				r.releaseVolumes(containerName, points)
+				return nil, nil, fmt.Errorf("bind source %s, err: %v", m.Source, err)
+			}
+		}
+		resolved.Mounts = append(resolved.Mounts, m)
+		if !isImageMount(m) {
+			points = append(points, mountPoint(m, name))
+		}
+	}
+	if spec.Bundle == "" {
+		sort.SliceStable(resolved.Mounts, func(i, j int) bool {
+			return mountDepth(resolved.Mounts[i]) < mountDepth(resolved.Mounts[j])
+		})
+	}
+	return &resolved, points, nil
+}
+
+// releaseVolumes drops a container which won't be created after all from
+// the users of its volumes.
+func (r *Runtime) releaseVolumes(containerName string, points []container.MountPoint) {
+	for _, point := range points {
+		if point.Name == "" {
+			continue
+		}
+		if err := r.VolumeStore().Release(point.Name, containerName); err != nil {
+			logrus.Warnf("release volume %s, err: %v", point.Name, err)
+		}
+	}
+}
+
+func mountDepth(m Mount) int {
+	return len(strings.Split(strings.Trim(path.Clean(m.Destination), "/"), "/"))
+}
+
+// isImageMount tells the default mounts of containers apart from the ones
+// asked for.
+func isImageMount(m Mount) bool {
+	for _, im := range imageMounts {
+		if im.Destination == m.Destination && im.Type == m.Type && im.Source == m.Source {
+			return true
+		}
+	}
+	return false
+}
+
+func mountPoint(m Mount, volume string) container.MountPoint {
+	point := container.MountPoint{
+		Type:        m.Type,
+		Name:        volume,
+		Destination: m.Destination,
+	}
+	switch {
+	case volume != "":
+		point.Type = MountTypeVolume
+	case point.Type == "" || contains(m.Options, "bind") || contains(m.Options, "rbind"):
+		point.Type = MountTypeBind
+	}
+	if point.Type == MountTypeVolume || point.Type == MountTypeBind {
+		point.Source = m.Source
+	}
+	for _, option := range m.Options {
+		switch {
+		case option == "ro":
+			point.ReadOnly = true
+		case option == "rw":
+			point.ReadOnly = false
+		case contains(propagations, option):
+			point.Propagation = option
+		}
+	}
+	return point
+}
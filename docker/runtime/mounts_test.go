@@ -0,0 +1,138 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Mount
+		wantErr bool
+	}{
+		{s: "/host:/data", want: Mount{Source: "/host", Destination: "/data", Type: MountTypeBind, Options: []string{"rbind"}}},
+		{s: "/host:/data:ro,rshared", want: Mount{Source: "/host", Destination: "/data", Type: MountTypeBind, Options: []string{"rbind", "ro", "rshared"}}},
+		{s: "pgdata:/data:rw", want: Mount{Source: "pgdata", Destination: "/data", Type: MountTypeVolume, Options: []string{"rbind", "rw"}}},
+		{s: "pgdata:/data:shared", wantErr: true},
+		{s: "/host:/data:exec", wantErr: true},
+		{s: "/host", wantErr: true},
+		{s: ":/data", wantErr: true},
+		{s: "/host:", wantErr: true},
+		{s: "/host:/data:ro:rw", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVolume(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseVolume(%s) = %+v, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVolume(%s) = %+v, %v, want %+v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Mount
+		wantErr bool
+	}{
+		{
+			s:    "src=pgdata,dst=/data",
+			want: Mount{Source: "pgdata", Destination: "/data", Type: MountTypeVolume, Options: []string{"rbind"}},
+		},
+		{
+			s:    "type=bind, source=/host, target=/data, readonly, bind-propagation=rslave",
+			want: Mount{Source: "/host", Destination: "/data", Type: MountTypeBind, Options: []string{"rbind", "ro", "rslave"}},
+		},
+		{
+			s:    "type=bind,src=/host,dst=/data,ro=false",
+			want: Mount{Source: "/host", Destination: "/data", Type: MountTypeBind, Options: []string{"rbind"}},
+		},
+		{
+			s:    "type=tmpfs,dst=/tmp,tmpfs-size=64m,tmpfs-mode=1777",
+			want: Mount{Source: "tmpfs", Destination: "/tmp", Type: MountTypeTmpfs, Options: []string{"nosuid", "nodev", "noexec", "size=64m", "mode=1777"}},
+		},
+		{s: "src=pgdata", wantErr: true},
+		{s: "dst=/data", wantErr: true},
+		{s: "type=tmpfs,src=/host,dst=/tmp", wantErr: true},
+		{s: "type=overlay,src=a,dst=/data", wantErr: true},
+		{s: "src=a,dst=/data,readonly=maybe", wantErr: true},
+		{s: "src=a,dst=/data,bind-propagation=private,bind-propagation=up", wantErr: true},
+		{s: "src=a,dst=/data,consistency=cached", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMount(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMount(%s) = %+v, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMount(%s) = %+v, %v, want %+v", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	got, err := ParseTmpfs("/run:size=1m,exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Mount{Source: "tmpfs", Destination: "/run", Type: MountTypeTmpfs, Options: []string{"nosuid", "nodev", "noexec", "size=1m", "exec"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if _, err := ParseTmpfs(":size=1m"); err == nil {
+		t.Fatalf("a tmpfs without destination is parsed")
+	}
+}
+
+func TestValidateRejectsEscapingMounts(t *testing.T) {
+	tests := []struct {
+		name  string
+		mount string
+		want  string
+	}{
+		{"volume name with parent", "../../etc:/data", "invalid volume name"},
+		{"volume name with slash", "src=a/b,dst=/data", "invalid volume name"},
+		{"hidden volume name", ".lock:/data", "invalid volume name"},
+		{"relative destination", "src=a,dst=data", "is not absolute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parse := ParseVolume
+			if strings.Contains(tt.mount, "=") {
+				parse = ParseMount
+			}
+			m, err := parse(tt.mount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec := &ContainerSpec{Image: "busybox", Cmd: []string{"sh"}, Mounts: []Mount{m}}
+			if err := spec.validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got err %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
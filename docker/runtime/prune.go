@@ -0,0 +1,206 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/cgroups"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/network"
+	"github.com/sirupsen/logrus"
+	"path"
+)
+
+// findOrphans cross-references the container records with what exists on
+// the host. Everything which is not held by a live container is returned,
+// in the order it has to be removed. Records of stopped containers are
+// included when withRecords is set.
+func (r *Runtime) findOrphans(infos []*container.ContainerInfo, withRecords bool) ([]common.Orphan, error) {
+	conf := r.Config
+	var live []*container.ContainerInfo
+	names := map[string]bool{}
+	ids := map[string]bool{}
+	recorded := map[string]bool{}
+	settingUp := false
+	for _, info := range infos {
+		recorded[info.Name] = true
+		if info.IsAlive() {
+			live = append(live, info)
+			names[info.Name] = true
+			ids[info.Id] = true
+			// the pid is recorded once the network is connected
+			if info.Status == container.CREATED && info.Pid == "" {
+				settingUp = true
+			}
+		}
+	}
+
+	// the address and port rules of a container being set up may not be
+	// recorded yet, the network is left alone until it is done
+	var orphans []common.Orphan
+	if !settingUp {
+		if err := network.Init(conf); err != nil {
+			return nil, fmt.Errorf("network init failed, err: %v", err)
+		}
+		networkOrphans, err := network.FindOrphans(conf, live)
+		if err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, networkOrphans...)
+	}
+
+	for _, id := range cgroups.ListChildren(conf.CgroupParent) {
+		if ids[id] {
+			continue
+		}
+		cgroupManager := cgroups.NewCGroupManager(path.Join(conf.CgroupParent, id))
+		orphans = append(orphans, common.Orphan{
+			Kind: "cgroup",
+			Name: cgroupManager.Path,
+			Remove: func() error {
+				cgroupManager.Destroy()
+				return nil
+			},
+		})
+	}
+
+	mountPoints, writeLayers, err := container.ListWorkSpaces(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range mountPoints {
+		if names[name] {
+			continue
+		}
+		name := name
+		orphans = append(orphans, common.Orphan{
+			Kind: "mount point",
+			Name: path.Join(conf.MntPath(), name),
+			Remove: func() error {
+				return container.RemoveMountPoint(conf, name)
+			},
+		})
+	}
+	for _, name := range writeLayers {
+		// stopped containers keep their write layer until they are removed
+		if names[name] || (recorded[name] && !withRecords) {
+			continue
+		}
+		name := name
+		orphans = append(orphans, common.Orphan{
+			Kind: "write layer",
+			Name: path.Join(conf.WriteLayerPath(), name),
+			Remove: func() error {
+				return container.RemoveWriteLayer(conf, name)
+			},
+		})
+	}
+
+	// layers are released once the mount points using them are gone
+	layerOrphans, err := r.ImageStore().FindOrphans(ids)
+	if err != nil {
+		return nil, err
+	}
+	orphans = append(orphans, layerOrphans...)
+
+	if withRecords {
+		for _, info := range infos {
+			if names[info.Name] {
+				continue
+			}
+			name := info.Name
+			orphans = append(orphans, common.Orphan{
+				Kind: "container",
+				Name: name,
+				Remove: func() error {
+					return container.DeleteContainerInfo(conf, name)
+				},
+			})
+		}
+	}
+	return orphans, nil
+}
+
+// Prune removes everything leaked by crashed runs as well as the records of
+// stopped containers. With dryRun it only lists what would be removed. The
+// returned orphans carry the result of their removal in Err.
+func (r *Runtime) Prune(dryRun bool) ([]common.Orphan, error) {
+	infos, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	orphans, err := r.findOrphans(infos, true)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return orphans, nil
+	}
+	failed := 0
+	for i := range orphans {
+		if err := orphans[i].Remove(); err != nil {
+			logrus.Errorf("remove %s %s, err: %v", orphans[i].Kind, orphans[i].Name, err)
+			orphans[i].Err = err
+			failed++
+		}
+	}
+	if failed > 0 {
+		return orphans, fmt.Errorf("failed to remove %d of %d resources", failed, len(orphans))
+	}
+	return orphans, nil
+}
+
+// Reconcile is meant to run when a client starts. Containers recorded as created or
+// running whose processes are all gone died with their monitor, their
+// resources are released and they are marked as stopped.
+func (r *Runtime) Reconcile() {
+	infos, err := r.List()
+	if err != nil {
+		logrus.Errorf("list containers, err: %v", err)
+		return
+	}
+	var stale []*container.ContainerInfo
+	for _, info := range infos {
+		if info.Status != container.STOP && !info.IsAlive() {
+			stale = append(stale, info)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	orphans, err := r.findOrphans(infos, false)
+	if err != nil {
+		logrus.Errorf("find leaked resources, err: %v", err)
+		return
+	}
+	for _, orphan := range orphans {
+		if err := orphan.Remove(); err != nil {
+			logrus.Errorf("remove %s %s, err: %v", orphan.Kind, orphan.Name, err)
+		}
+	}
+	for _, info := range stale {
+		logrus.Infof("container %s died unexpectedly, mark it as stopped", info.Name)
+		info.Status = container.STOP
+		info.Pid = ""
+		info.MonitorPid = ""
+		info.Network = ""
+		info.IPAddress = ""
+		if err := container.RecordContainerInfo(r.Config, info); err != nil {
+			logrus.Errorf("record container info, err: %v", err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/image"
+	"github.com/go-kinds/docker/registry"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	goruntime "runtime"
+	"strings"
+	"sync"
+)
+
+// maxConcurrentDownloads bounds the layers pulled at the same time
+const maxConcurrentDownloads = 3
+
+// PullOptions tune PullImage. Platform is os/arch and defaults to linux on
+// the architecture of the host. Progress gets a line per step if set.
+type PullOptions struct {
+	Platform string
+	Progress io.Writer
+}
+
+func (r *Runtime) registryClient() (*registry.Client, error) {
+	if r.Registry != nil {
+		return r.Registry, nil
+	}
+	credentialsFile := r.Config.CredentialsFile
+	if credentialsFile == "" {
+		credentialsFile = registry.DefaultCredentialsFile()
+	}
+	creds, err := registry.LoadCredentials(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	client := registry.NewClient(creds)
+	client.Insecure = r.Config.InsecureRegistries
+	r.Registry = client
+	return client, nil
+}
+
+// PullImage downloads an image from its registry into the image store.
+// Layers the store has already are skipped, the others are downloaded in
+// parallel and verified. Downloads of a failed pull are kept and resumed by
+// the next one.
+func (r *Runtime) PullImage(ref string, opts PullOptions) (*image.Image, error) {
+	progress := opts.Progress
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	goos, arch, err := parsePlatform(opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+	named, err := registry.ParseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	client, err := r.registryClient()
+	if err != nil {
+		return nil, err
+	}
+	store := r.ImageStore()
+
+	fmt.Fprintf(progress, "%s: Pulling from %s\n", named.Reference(), named.Repository)
+	content, mediaType, manifestDigest, err := client.GetManifest(named, named.Reference())
+	if err != nil {
+		return nil, err
+	}
+	if registry.IsIndex(mediaType) {
+		index := &v1.Index{}
+		if err := json.Unmarshal(content, index); err != nil {
+			return nil, fmt.Errorf("parse index of %s, err: %v", named, err)
+		}
+		desc, err := image.SelectPlatform(index.Manifests, goos, arch)
+		if err != nil {
+			return nil, fmt.Errorf("%s, err: %v", named, err)
+		}
+		content, _, _, err = client.GetManifest(named, desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+	}
+	manifest := &v1.Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest of %s, err: %v", named, err)
+	}
+
+	configPath, err := store.DownloadPath(manifest.Config.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := client.FetchBlob(named, manifest.Config, configPath); err != nil {
+		return nil, err
+	}
+	defer os.Remove(configPath)
+	configContent, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	config := &v1.Image{}
+	if err := json.Unmarshal(configContent, config); err != nil {
+		return nil, fmt.Errorf("parse image config of %s, err: %v", named, err)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("image config of %s lists %d layers, the manifest %d", named, len(config.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	previous, _ := store.Get(named.String())
+
+	layers, err := r.downloadLayers(client, named, manifest.Layers, config.RootFS.DiffIDs, progress)
+	if err != nil {
+		return nil, err
+	}
+	img, err := store.PutImage(configContent, layers, []string{named.String()})
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		_ = os.Remove(layer.Name)
+	}
+	// pushes to the same registry can skip or mount these blobs
+	for i, desc := range manifest.Layers {
+		blob := image.Blob{
+			Digest:       desc.Digest.String(),
+			Size:         desc.Size,
+			MediaType:    desc.MediaType,
+			Repositories: []string{named.Registry + "/" + named.Repository},
+		}
+		if err := store.RecordLayerBlob(config.RootFS.DiffIDs[i].String(), blob); err != nil {
+			logrus.Warnf("record blob of layer %s, err: %v", config.RootFS.DiffIDs[i], err)
+		}
+	}
+
+	fmt.Fprintf(progress, "Digest: %s\n", manifestDigest)
+	if previous != nil && previous.ID == img.ID {
+		fmt.Fprintf(progress, "Status: Image is up to date for %s\n", named)
+	} else {
+		fmt.Fprintf(progress, "Status: Downloaded newer image for %s\n", named)
+	}
+	return img, nil
+}
+
+// downloadLayers fetches the layers the store lacks, at most
+// maxConcurrentDownloads at a time.
+func (r *Runtime) downloadLayers(client *registry.Client, named registry.Reference, descs []v1.Descriptor, diffIDs []digest.Digest, progress io.Writer) ([]image.LayerBlob, error) {
+	store := r.ImageStore()
+	var (
+		layers   []image.LayerBlob
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, maxConcurrentDownloads)
+	fetching := map[string]bool{}
+	for i, desc := range descs {
+		diffID := diffIDs[i].String()
+		dest, err := store.DownloadPath(desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		short := shortDigest(desc.Digest.String())
+		if _, err := store.GetLayer(diffID); err == nil {
+			fmt.Fprintf(progress, "%s: Already exists\n", short)
+			continue
+		}
+		layers = append(layers, image.LayerBlob{
+			Name:   dest,
+			Open:   func() (io.ReadCloser, error) { return os.Open(dest) },
+			Digest: desc.Digest.String(),
+			DiffID: diffID,
+		})
+		// images may repeat a layer, empty ones especially
+		if fetching[dest] {
+			continue
+		}
+		fetching[dest] = true
+
+		wg.Add(1)
+		go func(desc v1.Descriptor) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			err := client.FetchBlob(named, desc, dest)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			fmt.Fprintf(progress, "%s: Download complete\n", short)
+		}(desc)
+	}
+	wg.Wait()
+	return layers, firstErr
+}
+
+// parsePlatform splits os/arch, the host architecture on linux by default.
+func parsePlatform(platform string) (string, string, error) {
+	if platform == "" {
+		return "linux", goruntime.GOARCH, nil
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid platform %s, should be os/arch like linux/arm64", platform)
+	}
+	return parts[0], parts[1], nil
+}
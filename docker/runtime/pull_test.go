@@ -0,0 +1,153 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/registry"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testImage is an image of one layer holding a file which names the
+// architecture, as a registry keeps it.
+type testImage struct {
+	manifest []byte
+	blobs    map[string][]byte
+}
+
+func newTestImage(t *testing.T, arch string) testImage {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	content := []byte(arch + "\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "arch", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(content)
+	tw.Close()
+	diffID := sha256.Sum256(layer.Bytes())
+
+	var compressed bytes.Buffer
+	gw := gzip.NewWriter(&compressed)
+	gw.Write(layer.Bytes())
+	gw.Close()
+
+	config, _ := json.Marshal(v1.Image{
+		Architecture: arch,
+		OS:           "linux",
+		RootFS:       v1.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.Digest("sha256:" + hex.EncodeToString(diffID[:]))}},
+	})
+	img := testImage{blobs: map[string][]byte{
+		registry.Digest(config):             config,
+		registry.Digest(compressed.Bytes()): compressed.Bytes(),
+	}}
+	img.manifest, _ = json.Marshal(v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    v1.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.Digest(registry.Digest(config)), Size: int64(len(config))},
+		Layers: []v1.Descriptor{{
+			MediaType: v1.MediaTypeImageLayerGzip,
+			Digest:    digest.Digest(registry.Digest(compressed.Bytes())),
+			Size:      int64(compressed.Len()),
+		}},
+	})
+	return img
+}
+
+func TestPullImageSelectsPlatformFromIndex(t *testing.T) {
+	images := map[string]testImage{
+		"amd64": newTestImage(t, "amd64"),
+		"arm64": newTestImage(t, "arm64"),
+	}
+	index := v1.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: v1.MediaTypeImageIndex}
+	manifests := map[string][]byte{}
+	blobs := map[string][]byte{}
+	for _, arch := range []string{"amd64", "arm64"} {
+		img := images[arch]
+		manifestDigest := registry.Digest(img.manifest)
+		manifests[manifestDigest] = img.manifest
+		index.Manifests = append(index.Manifests, v1.Descriptor{
+			MediaType: v1.MediaTypeImageManifest,
+			Digest:    digest.Digest(manifestDigest),
+			Size:      int64(len(img.manifest)),
+			Platform:  &v1.Platform{OS: "linux", Architecture: arch},
+		})
+		for d, content := range img.blobs {
+			blobs[d] = content
+		}
+	}
+	indexContent, _ := json.Marshal(index)
+	manifests["v1"] = indexContent
+
+	var (
+		mu        sync.Mutex
+		requested []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requested = append(requested, req.URL.Path)
+		mu.Unlock()
+		name := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/v2/team/app/manifests/") && manifests[name] != nil:
+			w.Header().Set("Content-Type", v1.MediaTypeImageManifest)
+			if name == "v1" {
+				w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+			}
+			w.Write(manifests[name])
+		case strings.HasPrefix(req.URL.Path, "/v2/team/app/blobs/") && blobs[name] != nil:
+			w.Write(blobs[name])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	r := New(&common.Config{Root: root, StateDir: root + "/state", StorageDriver: "overlay"})
+	r.Registry = registry.NewClient(registry.Credentials{})
+	ref := strings.TrimPrefix(server.URL, "http://") + "/team/app:v1"
+	img, err := r.PullImage(ref, PullOptions{Platform: "linux/arm64"})
+	if err != nil {
+		t.Fatalf("pull %s, err: %v", ref, err)
+	}
+	if img.Config.Architecture != "arm64" {
+		t.Fatalf("pulled an image for %s, want arm64", img.Config.Architecture)
+	}
+	amd64Manifest := "/v2/team/app/manifests/" + registry.Digest(images["amd64"].manifest)
+	mu.Lock()
+	for _, p := range requested {
+		if p == amd64Manifest {
+			t.Fatalf("the manifest of amd64 was fetched too")
+		}
+	}
+	mu.Unlock()
+
+	if _, err := r.PullImage(ref, PullOptions{Platform: "linux/s390x"}); err == nil || !strings.Contains(err.Error(), "no image for platform linux/s390x") {
+		t.Fatalf("got err %v pulling a platform the index lacks", err)
+	}
+}
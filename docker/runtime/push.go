@@ -0,0 +1,193 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/image"
+	"github.com/go-kinds/docker/registry"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// PushOptions tune PushImage. Blobs above ChunkSize are uploaded in chunks,
+// registry.DefaultChunkSize by default. Progress gets a line per step if
+// set.
+type PushOptions struct {
+	ChunkSize int64
+	Progress  io.Writer
+}
+
+// PushImage uploads a local image to the registry its name points to and
+// returns the digest of the manifest. Blobs the repository has are skipped,
+// blobs other repositories of the registry have are mounted.
+func (r *Runtime) PushImage(ref string, opts PushOptions) (string, error) {
+	progress := opts.Progress
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	named, err := registry.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	if named.Digest != "" {
+		return "", fmt.Errorf("cannot push %s, push a tag instead of a digest", ref)
+	}
+	store := r.ImageStore()
+	img, err := store.Get(ref)
+	if err != nil {
+		return "", err
+	}
+	client, err := r.registryClient()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(progress, "The push refers to repository [%s]\n", named.Name())
+
+	manifest := v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+	}
+	for _, diffID := range img.Layers {
+		desc, err := r.pushLayer(client, store, named, diffID, opts.ChunkSize, progress)
+		if err != nil {
+			return "", err
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+
+	configContent, err := store.GetBlob(img.ID)
+	if err != nil {
+		return "", err
+	}
+	exists, err := client.BlobExists(named, img.ID)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := client.UploadBlob(named, bytes.NewReader(configContent), int64(len(configContent)), img.ID, opts.ChunkSize); err != nil {
+			return "", err
+		}
+	}
+	manifest.Config = v1.Descriptor{
+		MediaType: v1.MediaTypeImageConfig,
+		Digest:    digest.Digest(img.ID),
+		Size:      int64(len(configContent)),
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	manifestDigest, err := client.PutManifest(named, named.Tag, v1.MediaTypeImageManifest, content)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(progress, "%s: digest: %s size: %d\n", named.Tag, manifestDigest, len(content))
+	return manifestDigest, nil
+}
+
+// pushLayer makes sure the repository has the blob of a layer. The blob the
+// layer was pulled or pushed as is tried first, a fresh one is compressed
+// otherwise.
+func (r *Runtime) pushLayer(client *registry.Client, store *image.Store, named registry.Reference, diffID string, chunkSize int64, progress io.Writer) (v1.Descriptor, error) {
+	layer, err := store.GetLayer(diffID)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	target := named.Registry + "/" + named.Repository
+	if blob := layer.Blob; blob != nil {
+		desc := blobDescriptor(blob)
+		short := shortDigest(blob.Digest)
+		exists, err := client.BlobExists(named, blob.Digest)
+		if err != nil {
+			return desc, err
+		}
+		if exists {
+			fmt.Fprintf(progress, "%s: Layer already exists\n", short)
+			return desc, store.RecordLayerBlob(diffID, image.Blob{Digest: blob.Digest, Repositories: []string{target}})
+		}
+		for _, source := range blob.Repositories {
+			sourceRegistry := source[:strings.Index(source, "/")]
+			sourceRepository := source[len(sourceRegistry)+1:]
+			if sourceRegistry != named.Registry || source == target {
+				continue
+			}
+			mounted, err := client.MountBlob(named, blob.Digest, sourceRepository)
+			if err != nil {
+				return desc, err
+			}
+			if mounted {
+				fmt.Fprintf(progress, "%s: Mounted from %s\n", short, sourceRepository)
+				return desc, store.RecordLayerBlob(diffID, image.Blob{Digest: blob.Digest, Repositories: []string{target}})
+			}
+		}
+	}
+
+	blobPath, blob, err := store.CompressLayer(diffID)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	defer os.Remove(blobPath)
+	desc := blobDescriptor(blob)
+	exists, err := client.BlobExists(named, blob.Digest)
+	if err != nil {
+		return desc, err
+	}
+	if exists {
+		fmt.Fprintf(progress, "%s: Layer already exists\n", shortDigest(blob.Digest))
+	} else {
+		f, err := os.Open(blobPath)
+		if err != nil {
+			return desc, err
+		}
+		defer f.Close()
+		if err := client.UploadBlob(named, f, blob.Size, blob.Digest, chunkSize); err != nil {
+			return desc, err
+		}
+		fmt.Fprintf(progress, "%s: Pushed\n", shortDigest(blob.Digest))
+	}
+	return desc, store.RecordLayerBlob(diffID, image.Blob{Digest: blob.Digest, Repositories: []string{target}})
+}
+
+// blobDescriptor describes a layer blob in an OCI manifest, docker media
+// types name the same content.
+func blobDescriptor(blob *image.Blob) v1.Descriptor {
+	mediaType := blob.MediaType
+	switch mediaType {
+	case registry.MediaTypeDockerLayer, "":
+		mediaType = v1.MediaTypeImageLayerGzip
+	}
+	return v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.Digest(blob.Digest),
+		Size:      blob.Size,
+	}
+}
+
+func shortDigest(d string) string {
+	hexPart := strings.TrimPrefix(d, "sha256:")
+	if len(hexPart) > 12 {
+		return hexPart[:12]
+	}
+	return hexPart
+}
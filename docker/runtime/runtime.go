@@ -0,0 +1,380 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package runtime is the embeddable api of go-docker. The cli is a thin
+// client of it, other go programs can drive containers the same way:
+//
+//	rt := runtime.New(common.DefaultConfig())
+//	rt.Binary = "/usr/local/bin/go-docker"
+//	info, err := rt.Create(&runtime.ContainerSpec{Image: "busybox", Cmd: []string{"top"}})
+//	err = rt.Start(info.Name)
+//	code, err := rt.Wait(info.Name)
+//
+// Every container is monitored by a shim process started from Binary, so
+// containers outlive the process that created them.
+package runtime
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/cgroups/subsystem"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/registry"
+	"github.com/go-kinds/docker/shim"
+	"github.com/go-kinds/docker/storage"
+	"github.com/go-kinds/docker/volume"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultBinary re-executes the current binary, which is right for the
+// go-docker cli itself. Programs embedding the runtime point Binary to an
+// installed go-docker instead.
+const DefaultBinary = "/proc/self/exe"
+
+type Runtime struct {
+	Config *common.Config
+	// Binary provides the hidden shim and init commands
+	Binary string
+	// Registry pulls images, nil makes one from the credentials file and the
+	// insecure registries of Config
+	Registry *registry.Client
+}
+
+// New fills in the storage driver of conf if it is empty, containers and
+// images of one runtime have to agree on it.
+func New(conf *common.Config) *Runtime {
+	if conf.StorageDriver == "" {
+		conf.StorageDriver = storage.Detect()
+	}
+	return &Runtime{
+		Config: conf,
+		Binary: DefaultBinary,
+	}
+}
+
+// Mount is a bind mount of a host path unless Type says otherwise, Options
+// are fstab style. Mounts of type volume name a volume as their Source.
+type Mount = container.Mount
+
+// ContainerSpec describes the container to create.
+type ContainerSpec struct {
+	// Name defaults to the generated container id
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Entrypoint replaces the one of the image, an empty non nil one drops
+	// it. Cmd follows the entrypoint and defaults to the Cmd of the image.
+	Entrypoint []string                  `json:"entrypoint,omitempty"`
+	Cmd        []string                  `json:"cmd"`
+	Env        []string                  `json:"env"`
+	Tty        bool                      `json:"tty"`
+	Mounts     []Mount                   `json:"mounts"`
+	Resources  *subsystem.ResourceConfig `json:"resources"`
+	Network    string                    `json:"network"`
+	// Ports are host_port:container_port pairs
+	Ports []string `json:"ports"`
+
+	// Rootfs is used as the root file system instead of a work space on top
+	// of Image, Mounts may be of any type then.
+	Rootfs         string `json:"rootfs,omitempty"`
+	RootfsReadonly bool   `json:"rootfs_readonly,omitempty"`
+	// RootfsLower mounts a write layer on top of Rootfs, the directory stays
+	// as it is
+	RootfsLower bool `json:"rootfs_lower,omitempty"`
+	// Bundle is the OCI bundle the spec was read from, if any
+	Bundle   string          `json:"bundle,omitempty"`
+	Hostname string          `json:"hostname,omitempty"`
+	Cwd      string          `json:"cwd,omitempty"`
+	User     *container.User `json:"user,omitempty"`
+	// Username is user[:group] by name or id, looked up in the root file
+	// system of the container when User is not set
+	Username string             `json:"username,omitempty"`
+	Rlimits  []container.Rlimit `json:"rlimits,omitempty"`
+	// Sysctls are written below /proc/sys of the container, only namespaced
+	// ones are accepted
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+	// Devices are created in the container and allowed by its devices
+	// cgroup, see ParseDevice
+	Devices []container.Device `json:"devices,omitempty"`
+	// MaskedPaths and ReadonlyPaths default to DefaultMaskedPaths and
+	// DefaultReadonlyPaths when nil, see ApplySecurityOpt. Empty lists are
+	// kept in json to tell them from nil.
+	MaskedPaths   []string `json:"masked_paths"`
+	ReadonlyPaths []string `json:"readonly_paths"`
+	// Namespaces lists the namespaces to create: pid, network, mount, ipc,
+	// uts and cgroup. Nil means all but cgroup.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Hooks run at the stages of the container life cycle, see AddHook
+	Hooks       *specs.Hooks      `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+var namespaceFlags = map[string]uintptr{
+	"pid":     syscall.CLONE_NEWPID,
+	"network": syscall.CLONE_NEWNET,
+	"mount":   syscall.CLONE_NEWNS,
+	"ipc":     syscall.CLONE_NEWIPC,
+	"uts":     syscall.CLONE_NEWUTS,
+	"cgroup":  unix.CLONE_NEWCGROUP,
+}
+
+func (s *ContainerSpec) cloneFlags() (uintptr, error) {
+	if s.Namespaces == nil {
+		return container.DefaultCloneFlags, nil
+	}
+	var flags uintptr
+	for _, ns := range s.Namespaces {
+		flag, ok := namespaceFlags[ns]
+		if !ok {
+			return 0, fmt.Errorf("unsupported namespace: %s", ns)
+		}
+		flags |= flag
+	}
+	// the init process sets up its mounts in its own namespace
+	if flags&syscall.CLONE_NEWNS == 0 {
+		return 0, fmt.Errorf("a mount namespace is required")
+	}
+	return flags, nil
+}
+
+func (s *ContainerSpec) validate() error {
+	if s.Image == "" && s.Rootfs == "" {
+		return fmt.Errorf("missing image")
+	}
+	if len(s.Cmd) == 0 {
+		return fmt.Errorf("missing container command")
+	}
+	if s.Name != "" && !container.ValidName(s.Name) {
+		return fmt.Errorf("invalid container name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", s.Name)
+	}
+	for _, m := range s.Mounts {
+		if m.Destination == "" || (s.Rootfs == "" && m.Source == "") {
+			return fmt.Errorf("mount needs both source and destination")
+		}
+		if !path.IsAbs(m.Destination) {
+			return fmt.Errorf("mount destination %s is not absolute", m.Destination)
+		}
+		if m.Type == MountTypeVolume && !volume.ValidName(m.Source) {
+			return fmt.Errorf("invalid volume name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", m.Source)
+		}
+	}
+	if s.Rootfs != "" && !path.IsAbs(s.Rootfs) {
+		return fmt.Errorf("rootfs %s is not absolute", s.Rootfs)
+	}
+	if s.Cwd != "" && !path.IsAbs(s.Cwd) {
+		return fmt.Errorf("cwd %s is not absolute", s.Cwd)
+	}
+	for _, rlimit := range s.Rlimits {
+		if err := container.ValidateRlimit(rlimit); err != nil {
+			return err
+		}
+	}
+	if err := validateHooks(s.Hooks); err != nil {
+		return err
+	}
+	flags, err := s.cloneFlags()
+	if err != nil {
+		return err
+	}
+	if s.Network != "" && flags&syscall.CLONE_NEWNET == 0 {
+		return fmt.Errorf("connecting a network needs a network namespace")
+	}
+	if s.Hostname != "" && flags&syscall.CLONE_NEWUTS == 0 {
+		return fmt.Errorf("setting the hostname needs a uts namespace")
+	}
+	for _, device := range s.Devices {
+		if !path.IsAbs(device.Path) {
+			return fmt.Errorf("device path %s is not absolute", device.Path)
+		}
+	}
+	for key := range s.Sysctls {
+		if err := validateSysctl(key, flags); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.Ports {
+		if len(strings.Split(p, ":")) != 2 {
+			return fmt.Errorf("invalid port mapping: %s, should be host_port:container_port", p)
+		}
+	}
+	if s.Resources == nil {
+		s.Resources = &subsystem.ResourceConfig{}
+	}
+	return nil
+}
+
+// DefaultPath is the PATH of containers on a root file system directory.
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// DefaultMounts returns the file systems containers on top of an image get,
+// for containers on a root file system directory which want the same.
+func DefaultMounts() []Mount {
+	return append([]Mount(nil), imageMounts...)
+}
+
+// imageMounts are the file systems of a container on top of an image, a
+// bundle lists its own.
+var imageMounts = []Mount{
+	{Source: "proc", Destination: "/proc", Type: "proc", Options: []string{"nosuid", "noexec", "nodev"}},
+	{Source: "tmpfs", Destination: "/dev", Type: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+	{Source: "devpts", Destination: "/dev/pts", Type: "devpts", Options: []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+	{Source: "shm", Destination: "/dev/shm", Type: "tmpfs", Options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+	{Source: "sysfs", Destination: "/sys", Type: "sysfs", Options: []string{"nosuid", "noexec", "nodev", "ro"}},
+}
+
+// rootfs is the directory the container pivots into, the work space unless
+// the container runs on Rootfs directly.
+func (s *ContainerSpec) rootfs(conf *common.Config, containerName string) string {
+	if s.Rootfs != "" && !s.RootfsLower {
+		return s.Rootfs
+	}
+	return path.Join(conf.MntPath(), containerName)
+}
+
+// initConfig tells the init process how to start the user command. The
+// process pivots into rootfs, the work space of image containers.
+func (s *ContainerSpec) initConfig(rootfs string) *container.InitConfig {
+	conf := &container.InitConfig{
+		Args:     s.Cmd,
+		Rootfs:   rootfs,
+		Hostname: s.Hostname,
+		Cwd:      s.Cwd,
+		User:     s.User,
+		Username: s.Username,
+		Rlimits:  s.Rlimits,
+		Sysctls:  s.Sysctls,
+		Devices:  s.Devices,
+
+		RootfsReadonly: s.RootfsReadonly,
+	}
+	conf.MaskedPaths, conf.ReadonlyPaths = s.systemPaths()
+	conf.Env = s.Env
+	if conf.Env == nil {
+		conf.Env = []string{}
+	}
+	if s.Rootfs == "" {
+		conf.Mounts = append(append([]Mount(nil), imageMounts...), s.Mounts...)
+		return conf
+	}
+	conf.Mounts = s.Mounts
+	return conf
+}
+
+func (r *Runtime) List() ([]*container.ContainerInfo, error) {
+	return container.ListContainerInfos(r.Config)
+}
+
+func (r *Runtime) Get(name string) (*container.ContainerInfo, error) {
+	return container.GetContainerInfo(r.Config, name)
+}
+
+// Start runs the user command of a created container.
+func (r *Runtime) Start(name string) error {
+	info, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	if info.Status != container.CREATED {
+		return fmt.Errorf("container %s is %s, only created containers can be started", name, info.Status)
+	}
+	_, err = r.control(name, shimStart)
+	return err
+}
+
+// Wait blocks until the container exits and returns its exit code.
+func (r *Runtime) Wait(name string) (int, error) {
+	info, err := r.Get(name)
+	if err != nil {
+		return -1, err
+	}
+	if info.Status == container.STOP {
+		return info.ExitCode, nil
+	}
+	resp, err := r.control(name, shimWait)
+	if err == nil {
+		return resp.ExitCode, nil
+	}
+	// the shim may have just gone, the record tells how it ended
+	if info, gerr := r.Get(name); gerr == nil && info.Status == container.STOP {
+		return info.ExitCode, nil
+	}
+	return -1, err
+}
+
+// Kill sends a signal to the init process of the container.
+func (r *Runtime) Kill(name string, sig syscall.Signal) error {
+	info, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	if info.Status == container.STOP || info.Pid == "" {
+		return fmt.Errorf("container %s is not running", name)
+	}
+	pid, err := strconv.Atoi(info.Pid)
+	if err != nil {
+		return fmt.Errorf("invalid pid %s of container %s", info.Pid, name)
+	}
+	return syscall.Kill(pid, sig)
+}
+
+// Delete removes a stopped container, its record, its write layer and its
+// use of volumes. A created container, whose command never ran, is killed
+// first, with force a running one too.
+func (r *Runtime) Delete(name string, force bool) error {
+	info, err := r.Get(name)
+	if err != nil {
+		return err
+	}
+	if info.Status != container.STOP && info.IsAlive() {
+		if info.Status == container.RUNNING && !force {
+			return fmt.Errorf("container %s is %s, stop it first or force the removal", name, info.Status)
+		}
+		if err := r.Kill(name, syscall.SIGKILL); err != nil {
+			return err
+		}
+		if _, err := r.Wait(name); err != nil {
+			return err
+		}
+	}
+	if err := container.RemoveWriteLayer(r.Config, name); err != nil {
+		return fmt.Errorf("remove write layer of container %s, err: %v", name, err)
+	}
+	r.releaseVolumes(name, info.Mounts)
+	return container.DeleteContainerInfo(r.Config, name)
+}
+
+// Attach connects to the stdio relay of a container. Use shim.Stream to
+// hook the connection up to a terminal.
+func (r *Runtime) Attach(name string) (net.Conn, error) {
+	info, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Status == container.STOP {
+		return nil, fmt.Errorf("container %s is not running", name)
+	}
+	return shim.Dial(path.Join(container.ContainerDir(r.Config, name), common.AttachSocket))
+}
+
+func now() string {
+	return time.Now().Format("2006-01-02 15:04:05")
+}
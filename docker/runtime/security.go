@@ -0,0 +1,112 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultMaskedPaths are hidden from containers, files behind /dev/null and
+// directories behind an empty read only tmpfs. They leak kernel memory,
+// keys and timing details of the host.
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths are remounted read only, writing them would change
+// the host kernel rather than the container.
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// ApplySecurityOpt changes spec as the --security-opt flag of run says:
+//
+//	systempaths=unconfined  neither mask nor remount any path
+//	mask=/a:/b              mask more paths
+//	unmask=/a:/b            leave paths alone, ALL for every path
+func ApplySecurityOpt(spec *ContainerSpec, opt string) error {
+	kv := strings.SplitN(opt, "=", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return fmt.Errorf("invalid security option %s, should be key=value", opt)
+	}
+	masked, readonly := spec.systemPaths()
+	switch kv[0] {
+	case "systempaths":
+		if kv[1] != "unconfined" {
+			return fmt.Errorf("invalid security option %s, only systempaths=unconfined is supported", opt)
+		}
+		masked, readonly = []string{}, []string{}
+	case "mask":
+		for _, p := range strings.Split(kv[1], ":") {
+			if !path.IsAbs(p) {
+				return fmt.Errorf("masked path %s is not absolute", p)
+			}
+			if !contains(masked, p) {
+				masked = append(masked, p)
+			}
+		}
+	case "unmask":
+		if kv[1] == "ALL" {
+			masked, readonly = []string{}, []string{}
+			break
+		}
+		for _, p := range strings.Split(kv[1], ":") {
+			masked, readonly = without(masked, p), without(readonly, p)
+		}
+	default:
+		return fmt.Errorf("unsupported security option %s, use systempaths, mask or unmask", kv[0])
+	}
+	spec.MaskedPaths, spec.ReadonlyPaths = masked, readonly
+	return nil
+}
+
+// systemPaths returns the paths to mask and to remount read only, the
+// defaults unless the spec has its own.
+func (s *ContainerSpec) systemPaths() (masked, readonly []string) {
+	masked, readonly = s.MaskedPaths, s.ReadonlyPaths
+	if masked == nil {
+		masked = append([]string{}, DefaultMaskedPaths...)
+	}
+	if readonly == nil {
+		readonly = append([]string{}, DefaultReadonlyPaths...)
+	}
+	return masked, readonly
+}
+
+func without(list []string, s string) []string {
+	kept := []string{}
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
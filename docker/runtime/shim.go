@@ -0,0 +1,371 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/cgroups"
+	"github.com/go-kinds/docker/common"
+	"github.com/go-kinds/docker/container"
+	"github.com/go-kinds/docker/image"
+	"github.com/go-kinds/docker/network"
+	"github.com/go-kinds/docker/shim"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// RunShim is the body of the hidden shim command. The shim is the parent of
+// the container: it owns the container stdio, serves the attach and control
+// sockets and reaps the container, tearing it down when it exits.
+func RunShim() error {
+	configPipe := os.NewFile(uintptr(3), "config")
+	readyPipe := os.NewFile(uintptr(4), "ready")
+	ready := func(err error) {
+		resp := &controlResponse{}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		_ = json.NewEncoder(readyPipe).Encode(resp)
+		_ = readyPipe.Close()
+	}
+
+	cfg := &shimConfig{}
+	err := json.NewDecoder(configPipe).Decode(cfg)
+	configPipe.Close()
+	if err != nil {
+		ready(fmt.Errorf("read shim config, err: %v", err))
+		return err
+	}
+	conf, info := cfg.Config, cfg.Info
+
+	dir := container.ContainerDir(conf, info.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		ready(err)
+		return err
+	}
+	if shimLog, err := os.OpenFile(path.Join(dir, common.ShimLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+		logrus.SetOutput(shimLog)
+		defer shimLog.Close()
+	}
+	logFile, err := os.OpenFile(path.Join(dir, common.ContainerLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		ready(err)
+		return err
+	}
+	defer logFile.Close()
+
+	// childFiles are the container side of the stdio, the shim drops its own
+	// copies once the container is started so that eof propagates.
+	var relay *shim.Relay
+	var childFiles []*os.File
+	defer func() {
+		for _, f := range childFiles {
+			f.Close()
+		}
+	}()
+	setupStdio := func(parent *exec.Cmd) error {
+		if info.Tty {
+			master, slave, err := container.NewConsole()
+			if err != nil {
+				return err
+			}
+			parent.Stdin = slave
+			parent.Stdout = slave
+			parent.Stderr = slave
+			parent.SysProcAttr.Setsid = true
+			parent.SysProcAttr.Setctty = true
+			parent.SysProcAttr.Ctty = 0
+			relay = shim.NewRelay(master, master, logFile)
+			childFiles = []*os.File{slave}
+			return nil
+		}
+		stdinRead, stdinWrite, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		outRead, outWrite, err := os.Pipe()
+		if err != nil {
+			stdinRead.Close()
+			stdinWrite.Close()
+			return err
+		}
+		parent.Stdin = stdinRead
+		parent.Stdout = outWrite
+		parent.Stderr = outWrite
+		relay = shim.NewRelay(stdinWrite, outRead, logFile)
+		childFiles = []*os.File{stdinRead, outWrite}
+		return nil
+	}
+
+	attachPath := path.Join(dir, common.AttachSocket)
+	controlPath := path.Join(dir, common.ControlSocket)
+	_ = os.Remove(attachPath)
+	_ = os.Remove(controlPath)
+	attachListener, err := net.Listen("unix", attachPath)
+	if err != nil {
+		ready(err)
+		return err
+	}
+	defer attachListener.Close()
+	controlListener, err := net.Listen("unix", controlPath)
+	if err != nil {
+		ready(err)
+		return err
+	}
+	defer controlListener.Close()
+
+	parent, writePipe, teardown, err := createContainer(cfg, setupStdio)
+	if err != nil {
+		_ = container.DeleteContainerInfo(conf, info.Name)
+		ready(err)
+		return err
+	}
+	for _, f := range childFiles {
+		f.Close()
+	}
+	childFiles = nil
+
+	var startOnce sync.Once
+	var startErr error
+	control := &controlServer{
+		exited: make(chan struct{}),
+		start: func() error {
+			startOnce.Do(func() {
+				startErr = startContainer(cfg, writePipe)
+			})
+			return startErr
+		},
+	}
+	ready(nil)
+
+	pumpDone := make(chan struct{})
+	go func() {
+		relay.Pump()
+		close(pumpDone)
+	}()
+	go relay.Serve(attachListener)
+	go control.serve(controlListener)
+
+	_ = parent.Wait()
+	<-pumpDone
+	attachListener.Close()
+	relay.Close()
+	_ = os.Remove(attachPath)
+	teardown.Unwind()
+
+	info.Status = container.STOP
+	info.ExitCode = exitCode(parent.ProcessState)
+	info.Pid = ""
+	info.MonitorPid = ""
+	if err := container.RecordContainerInfo(conf, info); err != nil {
+		logrus.Errorf("record container info, err: %v", err)
+	}
+	controlListener.Close()
+	_ = os.Remove(controlPath)
+	control.exit(info.ExitCode)
+	return nil
+}
+
+// exitCode follows the shell convention of 128+signal for killed processes.
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// createContainer sets the container up step by step: work space, cgroups,
+// init process and network. Each step registers its undo action, if any step
+// fails everything done so far is unwound. On success the init process waits
+// for the user command on the returned pipe, and the returned rollback tears
+// the container down once the init process has exited. The container record
+// is left to the caller.
+func createContainer(cfg *shimConfig, setupStdio func(cmd *exec.Cmd) error) (parent *exec.Cmd, writePipe *os.File, teardown *common.Rollback, err error) {
+	setup := common.NewRollback()
+	defer setup.UnwindOnError(&err)
+	created := false
+
+	// the record goes first, so every resource created below is covered by
+	// it if the process dies half way
+	conf, spec, info := cfg.Config, cfg.Spec, cfg.Info
+	info.MonitorPid = strconv.Itoa(os.Getpid())
+	if err = container.RecordContainerInfo(conf, info); err != nil {
+		return nil, nil, nil, fmt.Errorf("record container info, err: %v", err)
+	}
+	// registered first, so it runs last, both on teardown and when the
+	// setup fails
+	setup.Add("poststop hooks", func() error {
+		if err := runHooks(HookPoststop, spec.hooks(HookPoststop), hookState(cfg, container.STOP)); err != nil {
+			logrus.Warnf("%v", err)
+		}
+		return nil
+	})
+
+	// a bundle brings its own root file system, which may serve as the lower
+	// layer of a work space as well
+	var layerDirs []string
+	if spec.Rootfs == "" {
+		store := image.NewStore(conf)
+		var img *image.Image
+		if img, err = store.Get(info.ImageID); err != nil {
+			return nil, nil, nil, err
+		}
+		owner := image.ContainerOwner(info.Id)
+		if err = store.AcquireLayers(owner, img.Layers); err != nil {
+			return nil, nil, nil, fmt.Errorf("acquire image layers, err: %v", err)
+		}
+		setup.Add("image layers", func() error {
+			return store.ReleaseLayers(owner, img.Layers)
+		})
+		for i := len(img.Layers) - 1; i >= 0; i-- {
+			layerDirs = append(layerDirs, store.LayerDir(img.Layers[i]))
+		}
+	} else if spec.RootfsLower {
+		layerDirs = []string{spec.Rootfs}
+	}
+	if layerDirs != nil {
+		if err = container.NewWorkSpace(conf, info.Name, layerDirs); err != nil {
+			return nil, nil, nil, fmt.Errorf("new work space, err: %v", err)
+		}
+		// the write layer of a created container stays until the container
+		// is removed, it can be committed after the container exited
+		setup.Add("write layer", func() error {
+			if created {
+				return nil
+			}
+			return container.RemoveWriteLayer(conf, info.Name)
+		})
+		setup.Add("work space", func() error {
+			return container.UnmountWorkSpace(conf, info.Name)
+		})
+	}
+	dir := spec.rootfs(conf, info.Name)
+	spec.Env = spec.processEnv(dir)
+	info.Env = spec.Env
+	if err = writeSpec(conf, info.Name, spec); err != nil {
+		return nil, nil, nil, fmt.Errorf("record container spec, err: %v", err)
+	}
+
+	cloneFlags, err := spec.cloneFlags()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// the undo actions below must not use the named results, they are reset
+	// by the failing return before the unwinding runs
+	initCmd, initPipe, err := container.NewParentProcess(dir, cloneFlags, spec.Env)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("new parent process, err: %v", err)
+	}
+	setup.Add("init pipe", func() error {
+		// Start closes it on the regular path already
+		_ = initPipe.Close()
+		return nil
+	})
+	if err = setupStdio(initCmd); err != nil {
+		return nil, nil, nil, fmt.Errorf("set up stdio, err: %v", err)
+	}
+
+	// the cgroups are created before the process starts, so that unwinding
+	// kills the process before removing them
+	cgroupManager := cgroups.NewCGroupManager(path.Join(conf.CgroupParent, info.Id))
+	setup.Add("cgroups", func() error {
+		cgroupManager.Destroy()
+		return nil
+	})
+	if err = cgroupManager.Set(spec.Resources); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err = initCmd.Start(); err != nil {
+		return nil, nil, nil, fmt.Errorf("parent start failed, err: %v", err)
+	}
+	setup.Add("init process", func() error {
+		if initCmd.ProcessState != nil {
+			return nil
+		}
+		if err := initCmd.Process.Kill(); err != nil {
+			return err
+		}
+		_ = initCmd.Wait()
+		return nil
+	})
+	if err = cgroupManager.Apply(initCmd.Process.Pid); err != nil {
+		return nil, nil, nil, err
+	}
+
+	info.Pid = strconv.Itoa(initCmd.Process.Pid)
+	if spec.Network != "" {
+		if err = network.Init(conf); err != nil {
+			return nil, nil, nil, fmt.Errorf("network init failed, err: %v", err)
+		}
+		if err = network.Connect(conf, spec.Network, info); err != nil {
+			return nil, nil, nil, fmt.Errorf("connect network, err: %v", err)
+		}
+		setup.Add("network", func() error {
+			return network.Disconnect(conf, info)
+		})
+		// the address is recorded before the hooks run, a client cleaning up
+		// in the meantime must not take it for leaked
+		if err = container.RecordContainerInfo(conf, info); err != nil {
+			return nil, nil, nil, fmt.Errorf("record container info, err: %v", err)
+		}
+	}
+
+	for _, stage := range []string{HookPrestart, HookCreateRuntime} {
+		if err = runHooks(stage, spec.hooks(stage), hookState(cfg, "creating")); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if err = container.RecordContainerInfo(conf, info); err != nil {
+		return nil, nil, nil, fmt.Errorf("record container info, err: %v", err)
+	}
+	created = true
+	return initCmd, initPipe, setup, nil
+}
+
+// startContainer hands the init config over to the waiting init process.
+func startContainer(cfg *shimConfig, writePipe *os.File) error {
+	conf, info := cfg.Config, cfg.Info
+	info.Status = container.RUNNING
+	if err := container.RecordContainerInfo(conf, info); err != nil {
+		return fmt.Errorf("record container info, err: %v", err)
+	}
+	//  write init config to pipe when init start
+	if err := sendInitConfig(cfg.Spec.initConfig(cfg.Spec.rootfs(conf, info.Name)), writePipe); err != nil {
+		return fmt.Errorf("send init config, err: %v", err)
+	}
+	if err := runHooks(HookPoststart, cfg.Spec.hooks(HookPoststart), hookState(cfg, container.RUNNING)); err != nil {
+		logrus.Warnf("%v", err)
+	}
+	return nil
+}
+
+func sendInitConfig(initConfig *container.InitConfig, writePipe *os.File) error {
+	logrus.Infof("command all is %s", strings.Join(initConfig.Args, " "))
+	if err := json.NewEncoder(writePipe).Encode(initConfig); err != nil {
+		writePipe.Close()
+		return err
+	}
+	return writePipe.Close()
+}
@@ -0,0 +1,104 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package runtime
+
+import (
+	"github.com/go-kinds/docker/volume"
+	"syscall"
+)
+
+// VolumeStore keeps the named volumes of the runtime.
+func (r *Runtime) VolumeStore() *volume.Store {
+	return volume.NewStore(r.Config)
+}
+
+// VolumeOptions tune CreateVolume, an empty Driver is the local driver.
+type VolumeOptions struct {
+	Driver  string
+	Options map[string]string
+	Labels  map[string]string
+}
+
+// CreateVolume makes a volume, an empty name gets a random one.
+func (r *Runtime) CreateVolume(name string, opts VolumeOptions) (*volume.Volume, error) {
+	return r.VolumeStore().Create(name, opts.Driver, opts.Options, opts.Labels)
+}
+
+func (r *Runtime) ListVolumes() ([]*volume.Volume, error) {
+	return r.VolumeStore().List()
+}
+
+func (r *Runtime) GetVolume(name string) (*volume.Volume, error) {
+	return r.VolumeStore().Get(name)
+}
+
+// RemoveVolume deletes a volume no container uses, stopped containers have
+// to be removed first.
+func (r *Runtime) RemoveVolume(name string) error {
+	return r.VolumeStore().Remove(name, r.volumeUser)
+}
+
+// PruneVolumes deletes the volumes no container uses and returns their
+// names.
+func (r *Runtime) PruneVolumes() ([]string, error) {
+	store := r.VolumeStore()
+	volumes, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, v := range volumes {
+		if err := store.Remove(v.Name, r.volumeUser); err != nil {
+			if _, inUse := err.(*volume.InUseError); inUse {
+				continue
+			}
+			return removed, err
+		}
+		removed = append(removed, v.Name)
+	}
+	return removed, nil
+}
+
+// volumeUser names a container using the volume: one whose record mounts
+// it, or one still being created with it, whose record isn't written yet.
+func (r *Runtime) volumeUser(v *volume.Volume, users map[string]int) (string, error) {
+	infos, err := r.List()
+	if err != nil {
+		return "", err
+	}
+	recorded := map[string]bool{}
+	for _, info := range infos {
+		recorded[info.Name] = true
+		for _, m := range info.Mounts {
+			if m.Name == v.Name {
+				return info.Name, nil
+			}
+		}
+	}
+	for name, pid := range users {
+		if !recorded[name] && processAlive(pid) {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,184 @@
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package shim
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// ParseDetachKeys turns a sequence like "ctrl-p,ctrl-q" into the bytes the
+// terminal sends for it.
+func ParseDetachKeys(keys string) ([]byte, error) {
+	var seq []byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		switch {
+		case len(key) == 1:
+			seq = append(seq, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == 6:
+			c := key[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				seq = append(seq, c-'a'+1)
+			case c == '@':
+				seq = append(seq, 0)
+			case c >= '[' && c <= '_':
+				seq = append(seq, c-'['+27)
+			default:
+				return nil, fmt.Errorf("invalid detach key: %s", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key: %s", key)
+		}
+	}
+	return seq, nil
+}
+
+func Dial(socketPath string) (net.Conn, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connect %s, err: %v", socketPath, err)
+	}
+	return conn, nil
+}
+
+// Attach connects the current terminal to the relay listening on socketPath.
+// It returns when the container exits or the detach sequence is typed.
+func Attach(socketPath string, detachKeys []byte) error {
+	conn, err := Dial(socketPath)
+	if err != nil {
+		return err
+	}
+	if err := Stream(conn, true, detachKeys); err != ErrDetached {
+		return err
+	}
+	return nil
+}
+
+// Stream copies the container output of conn to stdout and, with withStdin,
+// stdin to the container until the container exits or the detach sequence
+// is typed, in which case ErrDetached is returned. It closes conn.
+func Stream(conn net.Conn, withStdin bool, detachKeys []byte) error {
+	defer conn.Close()
+
+	outputDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(os.Stdout, conn)
+		outputDone <- err
+	}()
+	if !withStdin {
+		return <-outputDone
+	}
+
+	if restore, err := setRawTerminal(int(os.Stdin.Fd())); err == nil {
+		defer restore()
+	}
+
+	inputDone := make(chan error, 1)
+	go func() {
+		inputDone <- copyWithDetach(conn, os.Stdin, detachKeys)
+	}()
+
+	select {
+	case err := <-outputDone:
+		return err
+	case err := <-inputDone:
+		if err == ErrDetached {
+			fmt.Fprint(os.Stdout, "\r\n")
+			return err
+		}
+		// stdin reached EOF, keep streaming until the container is done
+		if err == nil {
+			if c, ok := conn.(*net.UnixConn); ok {
+				c.CloseWrite()
+			}
+		}
+		return <-outputDone
+	}
+}
+
+var ErrDetached = fmt.Errorf("detached")
+
+// copyWithDetach copies src to dst and stops with ErrDetached as soon as the
+// detach sequence is seen. A partial match is held back until it either
+// completes or turns out to be regular input.
+func copyWithDetach(dst io.Writer, src io.Reader, keys []byte) error {
+	buf := make([]byte, 1024)
+	matched := 0
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			var out bytes.Buffer
+			for _, b := range buf[:n] {
+				if matched > 0 && b != keys[matched] {
+					out.Write(keys[:matched])
+					matched = 0
+				}
+				if len(keys) > 0 && b == keys[matched] {
+					matched++
+					if matched == len(keys) {
+						if out.Len() > 0 {
+							dst.Write(out.Bytes())
+						}
+						return ErrDetached
+					}
+					continue
+				}
+				out.WriteByte(b)
+			}
+			if out.Len() > 0 {
+				if _, werr := dst.Write(out.Bytes()); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setRawTerminal puts the terminal into raw mode, so the detach keys and
+// control characters reach the container untouched.
+func setRawTerminal(fd int) (func(), error) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	old := *termios
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, unix.TCSETS, &old)
+	}, nil
+}
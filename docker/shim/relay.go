@@ -0,0 +1,172 @@
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package shim
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// clientBacklog is how many reads of the container output an attached
+	// client may lag behind before it is dropped
+	clientBacklog = 256
+	// clientWriteTimeout bounds a single write to an attached client
+	clientWriteTimeout = 10 * time.Second
+)
+
+// Relay owns the stdio of a detached container. It always drains the
+// container output into a log so the container never blocks on a full pipe,
+// fans the output out to every attached client and forwards client input
+// back to the container. Every client has its own backlog and writer, a
+// stalled client is dropped instead of holding up the container.
+type Relay struct {
+	in  io.Writer
+	out io.Reader
+	log io.Writer
+
+	mu      sync.Mutex
+	clients map[net.Conn]*client
+	closed  bool
+	// writers are the running client writers, Close waits for them
+	writers sync.WaitGroup
+}
+
+type client struct {
+	conn net.Conn
+	out  chan []byte
+}
+
+func NewRelay(in io.Writer, out io.Reader, log io.Writer) *Relay {
+	return &Relay{
+		in:      in,
+		out:     out,
+		log:     log,
+		clients: map[net.Conn]*client{},
+	}
+}
+
+// Pump copies the container output until the container closes it.
+func (r *Relay) Pump() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.out.Read(buf)
+		if n > 0 {
+			r.broadcast(buf[:n])
+		}
+		if err != nil {
+			// a pty master returns EIO once the last slave fd is closed
+			return
+		}
+	}
+}
+
+func (r *Relay) broadcast(bs []byte) {
+	if _, err := r.log.Write(bs); err != nil {
+		logrus.Errorf("write container log, err: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.clients) == 0 {
+		return
+	}
+	// the read buffer is reused, the writers get a copy
+	data := append([]byte(nil), bs...)
+	for _, c := range r.clients {
+		select {
+		case c.out <- data:
+		default:
+			logrus.Infof("drop attached client, it lags behind")
+			r.removeLocked(c)
+			c.conn.Close()
+		}
+	}
+}
+
+// Serve accepts attach clients until the listener is closed.
+func (r *Relay) Serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn, out: make(chan []byte, clientBacklog)}
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			conn.Close()
+			return
+		}
+		r.clients[conn] = c
+		r.writers.Add(1)
+		r.mu.Unlock()
+		go r.write(c)
+		go r.forward(c)
+	}
+}
+
+// write sends the backlog of a client until the client is removed, then it
+// closes the connection.
+func (r *Relay) write(c *client) {
+	defer r.writers.Done()
+	defer c.conn.Close()
+	for bs := range c.out {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := c.conn.Write(bs); err != nil {
+			logrus.Infof("drop attached client, err: %v", err)
+			r.remove(c)
+			return
+		}
+	}
+}
+
+// forward copies the input of a client to the container. A client half
+// closes its connection at the end of its input and still wants the output,
+// so the client stays attached until its writer fails or the relay closes.
+func (r *Relay) forward(c *client) {
+	if _, err := io.Copy(r.in, c.conn); err != nil {
+		logrus.Infof("forward client input, err: %v", err)
+	}
+}
+
+func (r *Relay) remove(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeLocked(c)
+}
+
+// removeLocked stops sending output to a client, its writer closes the
+// connection once the backlog is written.
+func (r *Relay) removeLocked(c *client) {
+	if r.clients[c.conn] != c {
+		return
+	}
+	delete(r.clients, c.conn)
+	close(c.out)
+}
+
+// Close disconnects every attached client once what it was sent so far is
+// written, or its write timed out.
+func (r *Relay) Close() {
+	r.mu.Lock()
+	r.closed = true
+	for _, c := range r.clients {
+		r.removeLocked(c)
+	}
+	r.mu.Unlock()
+	r.writers.Wait()
+}
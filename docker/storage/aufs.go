@@ -0,0 +1,61 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package storage
+
+import (
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// aufsDriver uses the whiteout files of the layers as they are, aufs knows
+// them.
+type aufsDriver struct{}
+
+func (d *aufsDriver) Name() string {
+	return Aufs
+}
+
+func (d *aufsDriver) Whiteout(dir, name string) error {
+	return ioutil.WriteFile(path.Join(dir, archive.WhiteoutPrefix+name), nil, 0444)
+}
+
+func (d *aufsDriver) Opaque(dir string) error {
+	return ioutil.WriteFile(path.Join(dir, archive.WhiteoutOpaqueDir), nil, 0444)
+}
+
+// IsWhiteout is false, the whiteout files of aufs are layer content as they
+// are.
+func (d *aufsDriver) IsWhiteout(fi os.FileInfo) bool {
+	return false
+}
+
+func (d *aufsDriver) IsOpaque(dir string) (bool, error) {
+	return false, nil
+}
+
+func (d *aufsDriver) Mount(layerDirs []string, upperDir, workDir, target string) error {
+	dirs := fmt.Sprintf("dirs=%s=rw", upperDir)
+	for _, layerDir := range layerDirs {
+		dirs += fmt.Sprintf(":%s=ro+wh", layerDir)
+	}
+	if output, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", target).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount aufs, err: %v, %s", err, output)
+	}
+	return nil
+}
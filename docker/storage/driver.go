@@ -0,0 +1,79 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package storage stacks the read only image layers and the write layer of a
+// container into its root file system. Every driver keeps whiteouts in its
+// own format, so layers are unpacked for the driver they are used with.
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/go-kinds/docker/archive"
+	"os"
+	"strings"
+)
+
+const (
+	Aufs    = "aufs"
+	Overlay = "overlay"
+)
+
+// Driver mounts layers and writes whiteouts the way its file system expects
+// them.
+type Driver interface {
+	archive.WhiteoutConverter
+	Name() string
+	// Mount stacks the read only layers, the top layer first, below the
+	// writable upperDir at target. workDir is scratch space of the driver on
+	// the file system of upperDir.
+	Mount(layerDirs []string, upperDir, workDir, target string) error
+}
+
+var drivers = map[string]Driver{
+	Aufs:    &aufsDriver{},
+	Overlay: &overlayDriver{},
+}
+
+// Get returns the named driver, an empty name picks the driver the kernel
+// supports.
+func Get(name string) (Driver, error) {
+	if name == "" {
+		name = Detect()
+	}
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %s, supported are %s and %s", name, Aufs, Overlay)
+	}
+	return driver, nil
+}
+
+// Detect prefers aufs, which this runtime has always used, and falls back to
+// overlay otherwise.
+func Detect() string {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return Overlay
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == Aufs {
+			return Aufs
+		}
+	}
+	return Overlay
+}
@@ -0,0 +1,80 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package storage
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+const overlayOpaqueXattr = "trusted.overlay.opaque"
+
+// overlayDriver stores whiteouts as 0/0 character devices and opaque
+// directories as an xattr.
+type overlayDriver struct{}
+
+func (d *overlayDriver) Name() string {
+	return Overlay
+}
+
+func (d *overlayDriver) Whiteout(dir, name string) error {
+	target := path.Join(dir, name)
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	return unix.Mknod(target, unix.S_IFCHR, int(unix.Mkdev(0, 0)))
+}
+
+func (d *overlayDriver) Opaque(dir string) error {
+	return unix.Setxattr(dir, overlayOpaqueXattr, []byte("y"), 0)
+}
+
+func (d *overlayDriver) IsWhiteout(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && fi.Mode()&os.ModeCharDevice != 0 && st.Rdev == 0
+}
+
+func (d *overlayDriver) IsOpaque(dir string) (bool, error) {
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(dir, overlayOpaqueXattr, value)
+	if err == unix.ENODATA || err == unix.ENOTSUP {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return n == 1 && value[0] == 'y', nil
+}
+
+func (d *overlayDriver) Mount(layerDirs []string, upperDir, workDir, target string) error {
+	if len(layerDirs) == 0 {
+		return fmt.Errorf("overlay needs at least one layer")
+	}
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		return err
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(layerDirs, ":"), upperDir, workDir)
+	if len(options) >= os.Getpagesize() {
+		return fmt.Errorf("too many layers for overlay, the mount options exceed %d bytes", os.Getpagesize())
+	}
+	if err := unix.Mount("overlay", target, "overlay", 0, options); err != nil {
+		return fmt.Errorf("mount overlay, err: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+package volume
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Local keeps the data of a volume in a directory below the volume root.
+const Local = "local"
+
+// Driver provides the host directory of volumes, containers bind mount it.
+type Driver interface {
+	Name() string
+	// Create sets the volume up, options are driver specific
+	Create(name string, options map[string]string) error
+	// Path is the directory containers of the volume bind mount
+	Path(name string) string
+	Remove(name string) error
+}
+
+// NewDriver makes a driver which keeps its state below root, the directory
+// of all volumes.
+type NewDriver func(root string) Driver
+
+var drivers = map[string]NewDriver{
+	Local: newLocalDriver,
+}
+
+// Register adds a driver for volumes to use, e.g. one mounting a network
+// file system. Drivers have to be registered before volumes use them.
+func Register(name string, newDriver NewDriver) {
+	drivers[name] = newDriver
+}
+
+func getDriver(name, root string) (Driver, error) {
+	newDriver, ok := drivers[name]
+	if !ok {
+		var names []string
+		for name := range drivers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown volume driver %s, supported are %s", name, strings.Join(names, ", "))
+	}
+	return newDriver(root), nil
+}
+
+// dataDir is where the local driver keeps the files of a volume, next to
+// the metadata of the volume.
+const dataDir = "_data"
+
+type localDriver struct {
+	root string
+}
+
+func newLocalDriver(root string) Driver {
+	return &localDriver{root: root}
+}
+
+func (d *localDriver) Name() string {
+	return Local
+}
+
+func (d *localDriver) Create(name string, options map[string]string) error {
+	for key := range options {
+		return fmt.Errorf("unsupported option %s of the %s volume driver", key, Local)
+	}
+	return os.MkdirAll(d.Path(name), 0755)
+}
+
+func (d *localDriver) Path(name string) string {
+	return path.Join(d.root, name, dataDir)
+}
+
+func (d *localDriver) Remove(name string) error {
+	return os.RemoveAll(d.Path(name))
+}
@@ -0,0 +1,348 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package volume keeps named volumes, data which outlives the containers
+// using it. Below the volume directory of the root every volume has
+//
+//	<name>/volume.json        driver, labels and creation time
+//	<name>/users.json         containers created with the volume
+//	<name>/_data              the files of a volume of the local driver
+//
+// Which containers use a volume is known from their records, see the
+// runtime. Until the record of a container is written its user entry, taken
+// under the lock of the store, keeps the volume from being removed.
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kinds/docker/common"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const (
+	volumeFile = "volume.json"
+	usersFile  = "users.json"
+	lockFile   = ".lock"
+)
+
+// validName is what names a volume, a name never starts with a dot, so
+// the lock file can't be taken for a volume.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+type Volume struct {
+	Name   string            `json:"name"`
+	Driver string            `json:"driver"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Options are passed to the driver when the volume is created
+	Options    map[string]string `json:"options,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Mountpoint string            `json:"mountpoint"`
+}
+
+// notExistError tells that a volume doesn't exist, as opposed to one whose
+// metadata can't be read.
+type notExistError struct {
+	name string
+}
+
+func (e *notExistError) Error() string {
+	return fmt.Sprintf("no such volume: %s", e.name)
+}
+
+// IsNotExist tells whether err says that a volume doesn't exist.
+func IsNotExist(err error) bool {
+	_, ok := err.(*notExistError)
+	return ok
+}
+
+// InUseError refuses to remove a volume a container uses.
+type InUseError struct {
+	Name      string
+	Container string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("volume %s is in use by container %s", e.Name, e.Container)
+}
+
+type Store struct {
+	root string
+}
+
+func NewStore(cfg *common.Config) *Store {
+	return &Store{root: cfg.VolumePath()}
+}
+
+// ValidName tells whether name may name a volume.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Create makes a volume, an empty name gets a random one and an empty
+// driver is the local driver. A volume of the name which exists already is
+// returned as it is if it has the same driver.
+func (s *Store) Create(name, driver string, options, labels map[string]string) (*Volume, error) {
+	if driver == "" {
+		driver = Local
+	}
+	if name == "" {
+		name = randomName()
+	}
+	if !ValidName(name) {
+		return nil, fmt.Errorf("invalid volume name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.create(name, driver, options, labels)
+}
+
+func (s *Store) create(name, driver string, options, labels map[string]string) (*Volume, error) {
+	d, err := getDriver(driver, s.root)
+	if err != nil {
+		return nil, err
+	}
+	if v, err := s.get(name); err == nil {
+		if v.Driver != driver {
+			return nil, fmt.Errorf("volume %s exists with driver %s", name, v.Driver)
+		}
+		return v, nil
+	}
+	if err := os.MkdirAll(path.Join(s.root, name), 0700); err != nil {
+		return nil, err
+	}
+	if err := d.Create(name, options); err != nil {
+		_ = os.RemoveAll(path.Join(s.root, name))
+		return nil, fmt.Errorf("create volume %s, err: %v", name, err)
+	}
+	v := &Volume{
+		Name:       name,
+		Driver:     driver,
+		Labels:     labels,
+		Options:    options,
+		CreatedAt:  time.Now().UTC(),
+		Mountpoint: d.Path(name),
+	}
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path.Join(s.root, name, volumeFile), content, 0644); err != nil {
+		_ = d.Remove(name)
+		_ = os.RemoveAll(path.Join(s.root, name))
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *Store) Get(name string) (*Volume, error) {
+	if !ValidName(name) {
+		return nil, &notExistError{name: name}
+	}
+	return s.get(name)
+}
+
+// get reads the metadata of a volume. Volumes run -v made before volumes
+// had metadata are local ones.
+func (s *Store) get(name string) (*Volume, error) {
+	v := &Volume{}
+	content, err := ioutil.ReadFile(path.Join(s.root, name, volumeFile))
+	if err == nil {
+		if err := json.Unmarshal(content, v); err != nil {
+			return nil, fmt.Errorf("read volume %s, err: %v", name, err)
+		}
+		return v, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	data, err := os.Stat(path.Join(s.root, name, dataDir))
+	if err != nil || !data.IsDir() {
+		return nil, &notExistError{name: name}
+	}
+	return &Volume{
+		Name:       name,
+		Driver:     Local,
+		CreatedAt:  data.ModTime().UTC(),
+		Mountpoint: path.Join(s.root, name, dataDir),
+	}, nil
+}
+
+// List returns the volumes sorted by name.
+func (s *Store) List() ([]*Volume, error) {
+	entries, err := ioutil.ReadDir(s.root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var volumes []*Volume
+	for _, entry := range entries {
+		if !entry.IsDir() || !ValidName(entry.Name()) {
+			continue
+		}
+		if v, err := s.get(entry.Name()); err == nil {
+			volumes = append(volumes, v)
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// Use returns the volume a container is created with, a missing one is
+// made with the local driver. The container is recorded as a user of the
+// volume together with the process creating it.
+func (s *Store) Use(name, containerName string) (*Volume, error) {
+	if !ValidName(name) {
+		return nil, fmt.Errorf("invalid volume name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	v, err := s.get(name)
+	if IsNotExist(err) {
+		v, err = s.create(name, Local, nil, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.users(name)
+	if err != nil {
+		return nil, err
+	}
+	users[containerName] = os.Getpid()
+	return v, s.writeUsers(name, users)
+}
+
+// Release drops a container from the users of a volume, e.g. when it
+// could not be created after all.
+func (s *Store) Release(name, containerName string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	users, err := s.users(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := users[containerName]; !ok {
+		return nil
+	}
+	delete(users, containerName)
+	return s.writeUsers(name, users)
+}
+
+// users maps the containers created with a volume to the processes which
+// created them.
+func (s *Store) users(name string) (map[string]int, error) {
+	users := map[string]int{}
+	content, err := ioutil.ReadFile(path.Join(s.root, name, usersFile))
+	if os.IsNotExist(err) {
+		return users, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &users); err != nil {
+		return nil, fmt.Errorf("read users of volume %s, err: %v", name, err)
+	}
+	return users, nil
+}
+
+func (s *Store) writeUsers(name string, users map[string]int) error {
+	content, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(s.root, name, usersFile), content, 0644)
+}
+
+// Remove deletes a volume and its data. inUse is asked under the lock Use
+// takes, with the users Use recorded, which container still uses the
+// volume; an empty answer lets the volume go.
+func (s *Store) Remove(name string, inUse func(v *Volume, users map[string]int) (string, error)) error {
+	if !ValidName(name) {
+		return &notExistError{name: name}
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	v, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	d, err := getDriver(v.Driver, s.root)
+	if err != nil {
+		return err
+	}
+	users, err := s.users(name)
+	if err != nil {
+		return err
+	}
+	user, err := inUse(v, users)
+	if err != nil {
+		return err
+	}
+	if user != "" {
+		return &InUseError{Name: name, Container: user}
+	}
+	if err := d.Remove(name); err != nil {
+		return fmt.Errorf("remove volume %s, err: %v", name, err)
+	}
+	return os.RemoveAll(path.Join(s.root, name))
+}
+
+// lock serializes changes of the store between processes.
+func (s *Store) lock() (func(), error) {
+	if err := os.MkdirAll(s.root, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(s.root, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// randomName names anonymous volumes like docker does, 64 hex digits.
+func randomName() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}